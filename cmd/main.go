@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
@@ -9,6 +10,8 @@ import (
 	"tcp_proxy/internal/logger"
 	"tcp_proxy/internal/notifier"
 	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/service/replayer"
+	"time"
 )
 
 var (
@@ -17,6 +20,9 @@ var (
 
 func main() {
 	appLog := logger.NewAppSLogger()
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(appLog, os.Args[2:]))
+	}
 
 	appLog.Info("app starting", logger.WithString("conf", confFile))
 	appConf, err := config.LoadConfig(confFile)
@@ -43,3 +49,33 @@ func main() {
 		srvProxy.Stop()
 	}
 }
+
+// replay sends recorded requests to target and prints report, returns exit code 1 if anything differs
+func replay(appLog logger.AppLogger, args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	opts := replayer.Options{}
+	fs.StringVar(&opts.File, "file", "", "recording written by proxy with record section")
+	fs.StringVar(&opts.Target, "target", "", "base url of replay target, for example http://127.0.0.1:8545")
+	fs.Float64Var(&opts.Speed, "speed", 1, "1 keeps recorded pacing, 2 replays twice faster, 0 sends without pauses")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "timeout of single request")
+	maxDiffs := fs.Int("diffs", 20, "max diffs printed")
+	_ = fs.Parse(args)
+	if opts.File == "" || opts.Target == "" {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	report, err := replayer.NewService(opts).Run(ctx)
+	if report != nil {
+		report.Print(os.Stdout, *maxDiffs)
+	}
+	if err != nil {
+		appLog.Fatal("failed to replay recording", err, logger.WithString("file", opts.File))
+	}
+	if len(report.Diffs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,18 +2,71 @@ package entities
 
 import (
 	"fmt"
-	"strings"
+	"net"
 )
 
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+	ProtocolTLS  = "tls"
+	ProtocolUDP  = "udp"
+
+	ProtocolSOCKS5      = "socks5"
+	ProtocolHTTPConnect = "http_connect"
+	ProtocolDetected    = "detected" // protocol recognized by first bytes, detector name is in Method
+)
+
+// TLSHello describes ClientHello of encrypted connection, collected without decryption
+type TLSHello struct {
+	ServerName string
+	ALPN       []string
+	Versions   []string // supported versions offered by client
+	JA3        string
+	JA4        string
+}
+
+// ClientCert identifies client authenticated by certificate on terminated tls
+type ClientCert struct {
+	Subject     string
+	SANs        []string
+	Fingerprint string // sha256 of DER certificate, hex
+}
+
 type Notification struct {
+	Protocol    string
 	RemoteIP    string
 	RemoteURL   string
 	Method      string
 	ContentType string
 	Body        string
 	BodyLength  int64
+	ClientKey   string // name of credentials used by client, empty if proxy has no auth
+	Destination string
+	Status      string // grpc-status of finished call
+	TLS         *TLSHello
+	ClientCert  *ClientCert
+	Packets     int64 // datagrams of finished sessions in both directions
+	Bytes       int64 // payload bytes of finished sessions in both directions
 }
 
 func (d *Notification) NotifyID() string {
-	return fmt.Sprintf("%s-%s-%s", strings.Split(d.RemoteIP, ":")[0], d.Method, d.RemoteURL)
+	if d.Protocol == ProtocolUDP {
+		return fmt.Sprintf("%s-%s-%s", d.Protocol, remoteHost(d.RemoteIP), d.Destination)
+	}
+	if d.TLS != nil {
+		return fmt.Sprintf("%s-%s-%s-%s-%s", d.Protocol, remoteHost(d.RemoteIP), d.TLS.ServerName, d.TLS.JA4, d.Destination)
+	}
+	clientID := d.ClientKey
+	if d.ClientCert != nil {
+		clientID += "-" + d.ClientCert.Fingerprint
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", d.Protocol, remoteHost(d.RemoteIP), d.Method, d.RemoteURL, clientID, d.Destination, d.Status)
+}
+
+// remoteHost strips port, IPv6 addresses contain colons themselves
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
 }
@@ -0,0 +1,8 @@
+package entities
+
+type QuotaUsage struct {
+	Consumer string // api key name or client ip
+	Daily    int64
+	Monthly  int64
+	Rejected int64 // requests rejected since last report
+}
@@ -0,0 +1,45 @@
+package entities
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// RecordedExchange is one line of recording file, HTTP request with response of destination
+type RecordedExchange struct {
+	StartedAt   time.Time       `json:"started_at"`
+	Latency     time.Duration   `json:"latency_ns"` // from request accepted by proxy to response body finished
+	RemoteIP    string          `json:"remote_ip"`
+	Destination string          `json:"destination"`
+	Request     RecordedMessage `json:"request"`
+	Response    RecordedMessage `json:"response"`
+}
+
+type RecordedMessage struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Host       string      `json:"host,omitempty"`
+	Status     int         `json:"status,omitempty"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+	BodyBase64 bool        `json:"body_base64,omitempty"` // body is not valid utf-8
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+// SetBody keeps text bodies readable, binary ones are base64 encoded
+func (m *RecordedMessage) SetBody(body []byte) {
+	if utf8.Valid(body) {
+		m.Body, m.BodyBase64 = string(body), false
+		return
+	}
+	m.Body, m.BodyBase64 = base64.StdEncoding.EncodeToString(body), true
+}
+
+func (m *RecordedMessage) BodyBytes() ([]byte, error) {
+	if m.BodyBase64 {
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return []byte(m.Body), nil
+}
@@ -0,0 +1,242 @@
+package limiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"tcp_proxy/internal/entities"
+	"time"
+)
+
+const (
+	ByKey = "key"
+	ByIP  = "ip"
+
+	ReasonDaily   = "daily quota exceeded"
+	ReasonMonthly = "monthly quota exceeded"
+	ReasonRate    = "rate limit exceeded"
+
+	// OverflowConsumer shares counters of new consumers once max_consumers are tracked
+	OverflowConsumer = "overflow"
+
+	defaultMaxConsumers = 100_000
+	evictIdleInterval   = time.Second // limits sweeps of full limiter under flood of new consumers
+)
+
+type Config struct {
+	By            string  `yaml:"by"`              // key or ip, clients without key are limited by ip
+	Daily         int64   `yaml:"daily"`           // 0 means unlimited
+	Monthly       int64   `yaml:"monthly"`         // 0 means unlimited
+	RatePerSecond float64 `yaml:"rate_per_second"` // 0 means unlimited
+	Burst         int     `yaml:"burst"`
+	StateFile     string  `yaml:"state_file"`    // counters are saved here to survive restarts
+	MaxConsumers  int     `yaml:"max_consumers"` // tracked consumers, new ones share overflow counters above it. 100000 by default
+}
+
+type usage struct {
+	Day     string `json:"day"`
+	Daily   int64  `json:"daily"`
+	Month   string `json:"month"`
+	Monthly int64  `json:"monthly"`
+
+	rejected int64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	conf Config
+	now  func() time.Time
+
+	mu      sync.Mutex
+	usage   map[string]*usage
+	buckets map[string]*bucket
+	evicted time.Time // last sweep of idle consumers
+}
+
+func NewLimiter(conf Config) (*Limiter, error) {
+	switch conf.By {
+	case "", ByKey, ByIP:
+	default:
+		return nil, fmt.Errorf("unknown quota consumer type: %s", conf.By)
+	}
+	if conf.Burst <= 0 {
+		conf.Burst = max(1, int(math.Ceil(conf.RatePerSecond)))
+	}
+	if conf.MaxConsumers <= 0 {
+		conf.MaxConsumers = defaultMaxConsumers
+	}
+	return &Limiter{
+		conf:    conf,
+		now:     time.Now,
+		usage:   make(map[string]*usage, 1_000),
+		buckets: make(map[string]*bucket, 1_000),
+	}, nil
+}
+
+// Allow registers request of the consumer. In case of rejection returns reason and
+// duration after which request can be retried
+func (l *Limiter) Allow(consumer string) (retryAfter time.Duration, reason string, ok bool) {
+	now := l.now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.usage[consumer]; !found && len(l.usage) >= l.conf.MaxConsumers {
+		if now.Sub(l.evicted) >= evictIdleInterval {
+			l.evictIdle(now)
+		}
+		if len(l.usage) >= l.conf.MaxConsumers {
+			consumer = OverflowConsumer
+		}
+	}
+	u := l.getUsage(consumer, now)
+	if l.conf.Monthly > 0 && u.Monthly >= l.conf.Monthly {
+		u.rejected++
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now), ReasonMonthly, false
+	}
+	if l.conf.Daily > 0 && u.Daily >= l.conf.Daily {
+		u.rejected++
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now), ReasonDaily, false
+	}
+	if l.conf.RatePerSecond > 0 {
+		b, found := l.buckets[consumer]
+		if !found {
+			b = &bucket{tokens: float64(l.conf.Burst), last: now}
+			l.buckets[consumer] = b
+		}
+		b.tokens = math.Min(float64(l.conf.Burst), b.tokens+now.Sub(b.last).Seconds()*l.conf.RatePerSecond)
+		b.last = now
+		if b.tokens < 1 {
+			u.rejected++
+			return time.Duration((1 - b.tokens) / l.conf.RatePerSecond * float64(time.Second)), ReasonRate, false
+		}
+		b.tokens--
+	}
+	u.Daily++
+	u.Monthly++
+	return 0, "", true
+}
+
+func (l *Limiter) getUsage(consumer string, now time.Time) *usage {
+	u, ok := l.usage[consumer]
+	if !ok {
+		u = &usage{}
+		l.usage[consumer] = u
+	}
+	if day := now.Format(time.DateOnly); u.Day != day {
+		u.Day, u.Daily = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.Monthly = month, 0
+	}
+	return u
+}
+
+// TakeReport returns consumers rejected since previous call and top consumers by monthly usage
+func (l *Limiter) TakeReport(topSize int) (exceeded, top []entities.QuotaUsage) {
+	now := l.now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	all := make([]entities.QuotaUsage, 0, len(l.usage))
+	for consumer, u := range l.usage {
+		if outdated(u, now) { // consumer was not seen this month, only rejections of the last month are left to report
+			if u.rejected > 0 {
+				exceeded = append(exceeded, entities.QuotaUsage{Consumer: consumer, Daily: u.Daily, Monthly: u.Monthly, Rejected: u.rejected})
+			}
+			delete(l.usage, consumer)
+			continue
+		}
+		l.getUsage(consumer, now) // reset outdated daily counter
+		item := entities.QuotaUsage{Consumer: consumer, Daily: u.Daily, Monthly: u.Monthly, Rejected: u.rejected}
+		if u.rejected > 0 {
+			exceeded = append(exceeded, item)
+			u.rejected = 0
+		}
+		all = append(all, item)
+	}
+	sort.Slice(exceeded, func(i, j int) bool { return exceeded[i].Rejected > exceeded[j].Rejected })
+	sort.Slice(all, func(i, j int) bool { return all[i].Monthly > all[j].Monthly })
+	return exceeded, all[:min(topSize, len(all))]
+}
+
+// Load restores counters saved by Save, missing state file is not an error
+func (l *Limiter) Load() error {
+	if l.conf.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Clean(l.conf.StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error read quota state: %w", err)
+	}
+	state := make(map[string]*usage)
+	if err = json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("error decode quota state: %w", err)
+	}
+	now := l.now().UTC()
+	for consumer, u := range state {
+		if u == nil || outdated(u, now) {
+			delete(state, consumer)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.usage = state
+	return nil
+}
+
+// Save writes counters to the state file and drops idle rate buckets and consumers not seen this month
+func (l *Limiter) Save() error {
+	now := l.now().UTC()
+	l.mu.Lock()
+	l.evictIdle(now)
+	if l.conf.StateFile == "" {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(l.usage)
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encode quota state: %w", err)
+	}
+
+	tmpFile := l.conf.StateFile + ".tmp" // rename is atomic, so state is never half written
+	if err = os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return fmt.Errorf("error write quota state: %w", err)
+	}
+	if err = os.Rename(tmpFile, l.conf.StateFile); err != nil {
+		return fmt.Errorf("error replace quota state: %w", err)
+	}
+	return nil
+}
+
+// evictIdle drops consumers not seen this month and full rate buckets
+func (l *Limiter) evictIdle(now time.Time) {
+	l.evicted = now
+	for consumer, u := range l.usage {
+		if outdated(u, now) && u.rejected == 0 { // rejections wait for TakeReport
+			delete(l.usage, consumer)
+		}
+	}
+	for consumer, b := range l.buckets {
+		if now.Sub(b.last).Seconds()*l.conf.RatePerSecond >= float64(l.conf.Burst) {
+			delete(l.buckets, consumer) // bucket is full again, same as a new one
+		}
+	}
+}
+
+// outdated reports whether counters of consumer are from previous months
+func outdated(u *usage, now time.Time) bool {
+	return u.Month != now.Format("2006-01")
+}
@@ -0,0 +1,142 @@
+package limiter_test
+
+import (
+	"os"
+	"path/filepath"
+	"tcp_proxy/internal/limiter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Quota(t *testing.T) {
+	// given
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	l, err := limiter.NewLimiter(limiter.Config{Daily: 2, Monthly: 10, StateFile: stateFile})
+	require.NoError(t, err)
+
+	// when
+	for i := 0; i < 2; i++ {
+		_, _, ok := l.Allow("team-a")
+		require.True(t, ok)
+	}
+	retryAfter, reason, ok := l.Allow("team-a")
+
+	// then
+	require.False(t, ok)
+	require.Equal(t, limiter.ReasonDaily, reason)
+	require.True(t, retryAfter > 0 && retryAfter <= 24*time.Hour)
+	_, _, ok = l.Allow("team-b")
+	require.True(t, ok, "quota is tracked per consumer")
+
+	t.Run("report contains exceeded consumers once", func(t *testing.T) {
+		exceeded, top := l.TakeReport(1)
+		require.Len(t, exceeded, 1)
+		require.Equal(t, "team-a", exceeded[0].Consumer)
+		require.Equal(t, int64(1), exceeded[0].Rejected)
+		require.Len(t, top, 1)
+		require.Equal(t, "team-a", top[0].Consumer)
+		require.Equal(t, int64(2), top[0].Daily)
+
+		exceeded, _ = l.TakeReport(1)
+		require.Empty(t, exceeded)
+	})
+	t.Run("counters survive restart", func(t *testing.T) {
+		require.NoError(t, l.Save())
+		restored, err := limiter.NewLimiter(limiter.Config{Daily: 2, Monthly: 10, StateFile: stateFile})
+		require.NoError(t, err)
+		require.NoError(t, restored.Load())
+
+		_, _, ok = restored.Allow("team-a")
+		require.False(t, ok)
+		_, _, ok = restored.Allow("team-b")
+		require.True(t, ok)
+	})
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	// given
+	l, err := limiter.NewLimiter(limiter.Config{RatePerSecond: 1, Burst: 2})
+	require.NoError(t, err)
+
+	// when
+	_, _, ok1 := l.Allow("127.0.0.1")
+	_, _, ok2 := l.Allow("127.0.0.1")
+	retryAfter, reason, ok3 := l.Allow("127.0.0.1")
+
+	// then
+	require.True(t, ok1)
+	require.True(t, ok2)
+	require.False(t, ok3)
+	require.Equal(t, limiter.ReasonRate, reason)
+	require.True(t, retryAfter > 0 && retryAfter <= time.Second)
+	require.Eventually(t, func() bool {
+		_, _, ok := l.Allow("127.0.0.1")
+		return ok
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestLimiter_MaxConsumers(t *testing.T) {
+	// given
+	l, err := limiter.NewLimiter(limiter.Config{Daily: 2, MaxConsumers: 2})
+	require.NoError(t, err)
+	for _, consumer := range []string{"10.0.0.1", "10.0.0.2"} {
+		_, _, ok := l.Allow(consumer)
+		require.True(t, ok)
+	}
+
+	// when
+	_, _, ok1 := l.Allow("10.0.0.3")
+	_, _, ok2 := l.Allow("10.0.0.4")
+	_, reason, ok3 := l.Allow("10.0.0.5")
+
+	// then
+	require.True(t, ok1)
+	require.True(t, ok2)
+	require.False(t, ok3, "new consumers share overflow counters")
+	require.Equal(t, limiter.ReasonDaily, reason)
+	_, _, ok := l.Allow("10.0.0.1")
+	require.True(t, ok, "tracked consumers keep own counters")
+	exceeded, top := l.TakeReport(10)
+	require.Len(t, top, 3)
+	require.Equal(t, limiter.OverflowConsumer, exceeded[0].Consumer)
+}
+
+func TestLimiter_MissingState(t *testing.T) {
+	l, err := limiter.NewLimiter(limiter.Config{StateFile: filepath.Join(t.TempDir(), "missing.json")})
+	require.NoError(t, err)
+	require.NoError(t, l.Load())
+}
+
+func TestLimiter_OutdatedState(t *testing.T) {
+	// given
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	month := time.Now().UTC().Format("2006-01")
+	require.NoError(t, os.WriteFile(stateFile, []byte(`{
+		"old": {"day": "2020-01-31", "daily": 5, "month": "2020-01", "monthly": 50},
+		"broken": null,
+		"current": {"day": "2020-01-31", "daily": 5, "month": "`+month+`", "monthly": 7}
+	}`), 0o600))
+	l, err := limiter.NewLimiter(limiter.Config{Monthly: 10, StateFile: stateFile})
+	require.NoError(t, err)
+
+	// when
+	require.NoError(t, l.Load())
+	_, top := l.TakeReport(10)
+	require.NoError(t, l.Save())
+
+	// then
+	require.Len(t, top, 1)
+	require.Equal(t, "current", top[0].Consumer)
+	require.Equal(t, int64(7), top[0].Monthly)
+	data, err := os.ReadFile(stateFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "old", "consumers of previous months are forgotten")
+	require.NotContains(t, string(data), "broken")
+}
+
+func TestLimiter_UnknownConsumerType(t *testing.T) {
+	_, err := limiter.NewLimiter(limiter.Config{By: "client"})
+	require.Error(t, err)
+}
@@ -15,5 +15,12 @@ type Notificator interface {
 	SendInfoMessage(message string, args ...string) error
 	SendTaskErrMessage(service string, startedAt, finishedAt time.Time, message string, errs ...Object) error
 	SendInfoNewRequest(n *entities.Notification, destination string, counts int) error
-	SendInfoNewGRPCRequest(remoteIP, destination string) error
+	SendInfoNewGRPCRequest(n *entities.Notification, destination string, counts int) error
+	SendInfoNewTLSRequest(n *entities.Notification, destination string, counts int) error
+	SendInfoNewSession(n *entities.Notification, destination string, counts int) error
+	SendInfoProtocolDetected(n *entities.Notification, destination string, counts int) error
+	SendInfoMirrorMismatch(n *entities.Notification, mirror string, counts int) error
+	SendInfoAuthFailed(remoteIP, destination string, counts int) error
+	SendInfoUpstreamFailed(destination, stage string, counts int) error
+	SendQuotaReport(destination string, exceeded, top []entities.QuotaUsage) error
 }
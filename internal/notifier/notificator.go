@@ -62,10 +62,132 @@ func NewService(l logger.AppLogger, boxName, slackHookURL string) *Service {
 	}
 }
 
-func (s *Service) SendInfoNewGRPCRequest(remoteIP, destination string) error {
+func (s *Service) SendInfoNewGRPCRequest(n *entities.Notification, destination string, counts int) error {
+	headerText := ":eyes: observe new unsecure grpc request"
+	if counts > 1 {
+		headerText = fmt.Sprintf(":eyes: observe new unsecure grpc request (%d counts)", counts)
+	}
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(headerText),
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					slackField("From", n.RemoteIP),
+					slackField("Method", n.RemoteURL),
+				},
+			},
+			s.getContextWithExtra(append([]string{
+				fmt.Sprintf("grpc-status: *%s*", n.Status),
+				fmt.Sprintf("content-length: *%d*", n.BodyLength),
+				fmt.Sprintf("content-type: %s", n.ContentType),
+				fmt.Sprintf("destination: %s", destination),
+			}, clientCertContext(n)...)...),
+		},
+	})
+}
+
+func (s *Service) SendInfoNewTLSRequest(n *entities.Notification, destination string, counts int) error {
+	headerText := ":lock: observe new tls client"
+	if counts > 1 {
+		headerText = fmt.Sprintf(":lock: observe new tls client (%d counts)", counts)
+	}
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(headerText),
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					slackField("From", n.RemoteIP),
+					slackField("SNI", n.TLS.ServerName),
+					slackField("JA3", n.TLS.JA3),
+					slackField("JA4", n.TLS.JA4),
+				},
+			},
+			s.getContextWithExtra(
+				fmt.Sprintf("alpn: %s", strings.Join(n.TLS.ALPN, ",")),
+				fmt.Sprintf("versions: %s", strings.Join(n.TLS.Versions, ",")),
+				fmt.Sprintf("destination: %s", destination),
+			),
+		},
+	})
+}
+
+func (s *Service) SendInfoNewSession(n *entities.Notification, destination string, counts int) error {
+	headerText := fmt.Sprintf(":satellite: observe new %s session", n.Protocol)
+	if counts > 1 {
+		headerText = fmt.Sprintf(":satellite: observe new %s sessions (%d counts)", n.Protocol, counts)
+	}
+	fields := []any{
+		slackField("From", n.RemoteIP),
+		slackField("To", destination),
+	}
+	if n.RemoteURL != "" { // destination requested by client of forward proxy
+		fields = append(fields, slackField("Target", n.RemoteURL))
+	}
+	if n.ClientKey != "" {
+		fields = append(fields, slackField("Client", n.ClientKey))
+	}
+	extra := []string{fmt.Sprintf("bytes: *%d*", n.Bytes)}
+	if n.Packets > 0 {
+		extra = append([]string{fmt.Sprintf("packets: *%d*", n.Packets)}, extra...)
+	}
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(headerText),
+			map[string]any{
+				"type":   "section",
+				"fields": fields,
+			},
+			s.getContextWithExtra(extra...),
+		},
+	})
+}
+
+func (s *Service) SendInfoProtocolDetected(n *entities.Notification, destination string, counts int) error {
+	headerText := fmt.Sprintf(":mag: observe %s traffic (%s)", n.Method, n.Status)
+	if counts > 1 {
+		headerText = fmt.Sprintf(":mag: observe %s traffic (%s, %d counts)", n.Method, n.Status, counts)
+	}
 	return s.sendSlackMessage(map[string]any{
 		"blocks": []any{
-			getHeader(":eyes: observe new unsecure grpc request"),
+			getHeader(headerText),
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					slackField("From", n.RemoteIP),
+					slackField("To", destination),
+				},
+			},
+			s.getContextWithExtra(
+				fmt.Sprintf("first bytes: `%s`", n.Body),
+			),
+		},
+	})
+}
+
+func (s *Service) SendInfoMirrorMismatch(n *entities.Notification, mirror string, counts int) error {
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(fmt.Sprintf(":twisted_rightwards_arrows: mirror answered %s differently (%d counts)", n.Method, counts)),
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					slackField("Mirror", mirror),
+					slackField("Status", n.Status),
+				},
+			},
+			s.getContextWithExtra(
+				fmt.Sprintf("payload: `%s`", n.Body),
+			),
+		},
+	})
+}
+
+func (s *Service) SendInfoAuthFailed(remoteIP, destination string, counts int) error {
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(fmt.Sprintf(":no_entry: failed authentication attempts (%d counts)", counts)),
 			map[string]any{
 				"type": "section",
 				"fields": []any{
@@ -78,6 +200,22 @@ func (s *Service) SendInfoNewGRPCRequest(remoteIP, destination string) error {
 	})
 }
 
+func (s *Service) SendInfoUpstreamFailed(destination, stage string, counts int) error {
+	return s.sendSlackMessage(map[string]any{
+		"blocks": []any{
+			getHeader(fmt.Sprintf(":rotating_light: failed connections to upstream (%d counts)", counts)),
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					slackField("To", destination),
+					slackField("Stage", stage),
+				},
+			},
+			s.getContext(),
+		},
+	})
+}
+
 func (s *Service) SendInfoNewRequest(n *entities.Notification, destination string, counts int) error {
 	headerText := ":eyes: observe new http request"
 	if counts > 1 {
@@ -90,6 +228,7 @@ func (s *Service) SendInfoNewRequest(n *entities.Notification, destination strin
 			"fields": []any{
 				slackField("From", n.RemoteIP),
 				slackField("URL", n.RemoteURL),
+				slackField("Client", n.ClientKey),
 			},
 		},
 	}
@@ -106,12 +245,12 @@ func (s *Service) SendInfoNewRequest(n *entities.Notification, destination strin
 			},
 		})
 	}
-	blocks = append(blocks, s.getContextWithExtra(
+	blocks = append(blocks, s.getContextWithExtra(append([]string{
 		fmt.Sprintf("content-length: *%d*", n.BodyLength),
 		fmt.Sprintf("content-type: %s", n.ContentType),
 		fmt.Sprintf("method: %s", n.Method),
 		fmt.Sprintf("destination: %s", destination),
-	))
+	}, clientCertContext(n)...)...))
 	return s.sendSlackMessage(map[string]any{"blocks": blocks})
 }
 
@@ -204,6 +343,52 @@ func (s *Service) SendTaskErrMessage(service string, startedAt, finishedAt time.
 	})
 }
 
+func (s *Service) SendQuotaReport(destination string, exceeded, top []entities.QuotaUsage) error {
+	if len(exceeded) == 0 {
+		return nil
+	}
+	exceededBlock := slackBlock{
+		Type:     "rich_text",
+		BlockID:  "block1",
+		Elements: make([]element, 0, len(exceeded)),
+	}
+	for i := range exceeded {
+		exceededBlock.Elements = append(exceededBlock.Elements, element{
+			Type: "rich_text_section",
+			Elements: []item{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("%s: %d rejected requests\n", exceeded[i].Consumer, exceeded[i].Rejected),
+				},
+			},
+		})
+	}
+	topText := strings.Builder{}
+	topText.WriteString(fmt.Sprintf("%-40s %12s %12s\n", "consumer", "daily", "monthly"))
+	for i := range top {
+		topText.WriteString(fmt.Sprintf("%-40s %12d %12d\n", top[i].Consumer, top[i].Daily, top[i].Monthly))
+	}
+	exceededBlock.Elements = append(exceededBlock.Elements, element{
+		Type: "rich_text_preformatted",
+		Elements: []item{
+			{
+				Type: "text",
+				Text: topText.String(),
+			},
+		},
+	})
+
+	return s.sendSlackMessage(map[string][]slackBlock{
+		"blocks": {
+			getHeader(fmt.Sprintf(":bangbang: Quota exceeded: %s", destination)),
+			divider,
+			exceededBlock,
+			divider,
+			s.getContext(),
+		},
+	})
+}
+
 func (s *Service) SendInfoMessage(message string, args ...string) error {
 	return s.sendInfoMessage(message, args...)
 }
@@ -289,6 +474,17 @@ func (s *Service) getContext() slackBlock {
 	return res
 }
 
+func clientCertContext(n *entities.Notification) []string {
+	if n.ClientCert == nil {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("client cert: *%s*", n.ClientCert.Subject),
+		fmt.Sprintf("sans: %s", strings.Join(n.ClientCert.SANs, ",")),
+		fmt.Sprintf("fingerprint: %s", n.ClientCert.Fingerprint),
+	}
+}
+
 func slackField(title, value string) map[string]any {
 	if value == "" {
 		value = "-"
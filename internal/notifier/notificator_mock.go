@@ -21,6 +21,7 @@ import (
 type MockNotificator struct {
 	ctrl     *gomock.Controller
 	recorder *MockNotificatorMockRecorder
+	isgomock struct{}
 }
 
 // MockNotificatorMockRecorder is the mock recorder for MockNotificator.
@@ -40,6 +41,20 @@ func (m *MockNotificator) EXPECT() *MockNotificatorMockRecorder {
 	return m.recorder
 }
 
+// SendInfoAuthFailed mocks base method.
+func (m *MockNotificator) SendInfoAuthFailed(remoteIP, destination string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoAuthFailed", remoteIP, destination, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoAuthFailed indicates an expected call of SendInfoAuthFailed.
+func (mr *MockNotificatorMockRecorder) SendInfoAuthFailed(remoteIP, destination, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoAuthFailed", reflect.TypeOf((*MockNotificator)(nil).SendInfoAuthFailed), remoteIP, destination, counts)
+}
+
 // SendInfoMessage mocks base method.
 func (m *MockNotificator) SendInfoMessage(message string, args ...string) error {
 	m.ctrl.T.Helper()
@@ -59,18 +74,32 @@ func (mr *MockNotificatorMockRecorder) SendInfoMessage(message any, args ...any)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoMessage", reflect.TypeOf((*MockNotificator)(nil).SendInfoMessage), varargs...)
 }
 
+// SendInfoMirrorMismatch mocks base method.
+func (m *MockNotificator) SendInfoMirrorMismatch(n *entities.Notification, mirror string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoMirrorMismatch", n, mirror, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoMirrorMismatch indicates an expected call of SendInfoMirrorMismatch.
+func (mr *MockNotificatorMockRecorder) SendInfoMirrorMismatch(n, mirror, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoMirrorMismatch", reflect.TypeOf((*MockNotificator)(nil).SendInfoMirrorMismatch), n, mirror, counts)
+}
+
 // SendInfoNewGRPCRequest mocks base method.
-func (m *MockNotificator) SendInfoNewGRPCRequest(remoteIP, destination string) error {
+func (m *MockNotificator) SendInfoNewGRPCRequest(n *entities.Notification, destination string, counts int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SendInfoNewGRPCRequest", remoteIP, destination)
+	ret := m.ctrl.Call(m, "SendInfoNewGRPCRequest", n, destination, counts)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SendInfoNewGRPCRequest indicates an expected call of SendInfoNewGRPCRequest.
-func (mr *MockNotificatorMockRecorder) SendInfoNewGRPCRequest(remoteIP, destination any) *gomock.Call {
+func (mr *MockNotificatorMockRecorder) SendInfoNewGRPCRequest(n, destination, counts any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoNewGRPCRequest", reflect.TypeOf((*MockNotificator)(nil).SendInfoNewGRPCRequest), remoteIP, destination)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoNewGRPCRequest", reflect.TypeOf((*MockNotificator)(nil).SendInfoNewGRPCRequest), n, destination, counts)
 }
 
 // SendInfoNewRequest mocks base method.
@@ -87,6 +116,76 @@ func (mr *MockNotificatorMockRecorder) SendInfoNewRequest(n, destination, counts
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoNewRequest", reflect.TypeOf((*MockNotificator)(nil).SendInfoNewRequest), n, destination, counts)
 }
 
+// SendInfoNewSession mocks base method.
+func (m *MockNotificator) SendInfoNewSession(n *entities.Notification, destination string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoNewSession", n, destination, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoNewSession indicates an expected call of SendInfoNewSession.
+func (mr *MockNotificatorMockRecorder) SendInfoNewSession(n, destination, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoNewSession", reflect.TypeOf((*MockNotificator)(nil).SendInfoNewSession), n, destination, counts)
+}
+
+// SendInfoNewTLSRequest mocks base method.
+func (m *MockNotificator) SendInfoNewTLSRequest(n *entities.Notification, destination string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoNewTLSRequest", n, destination, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoNewTLSRequest indicates an expected call of SendInfoNewTLSRequest.
+func (mr *MockNotificatorMockRecorder) SendInfoNewTLSRequest(n, destination, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoNewTLSRequest", reflect.TypeOf((*MockNotificator)(nil).SendInfoNewTLSRequest), n, destination, counts)
+}
+
+// SendInfoProtocolDetected mocks base method.
+func (m *MockNotificator) SendInfoProtocolDetected(n *entities.Notification, destination string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoProtocolDetected", n, destination, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoProtocolDetected indicates an expected call of SendInfoProtocolDetected.
+func (mr *MockNotificatorMockRecorder) SendInfoProtocolDetected(n, destination, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoProtocolDetected", reflect.TypeOf((*MockNotificator)(nil).SendInfoProtocolDetected), n, destination, counts)
+}
+
+// SendInfoUpstreamFailed mocks base method.
+func (m *MockNotificator) SendInfoUpstreamFailed(destination, stage string, counts int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendInfoUpstreamFailed", destination, stage, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendInfoUpstreamFailed indicates an expected call of SendInfoUpstreamFailed.
+func (mr *MockNotificatorMockRecorder) SendInfoUpstreamFailed(destination, stage, counts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendInfoUpstreamFailed", reflect.TypeOf((*MockNotificator)(nil).SendInfoUpstreamFailed), destination, stage, counts)
+}
+
+// SendQuotaReport mocks base method.
+func (m *MockNotificator) SendQuotaReport(destination string, exceeded, top []entities.QuotaUsage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendQuotaReport", destination, exceeded, top)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendQuotaReport indicates an expected call of SendQuotaReport.
+func (mr *MockNotificatorMockRecorder) SendQuotaReport(destination, exceeded, top any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendQuotaReport", reflect.TypeOf((*MockNotificator)(nil).SendQuotaReport), destination, exceeded, top)
+}
+
 // SendTaskErrMessage mocks base method.
 func (m *MockNotificator) SendTaskErrMessage(service string, startedAt, finishedAt time.Time, message string, errs ...Object) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,143 @@
+package proxier
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	AuthTypeAPIKey = "api_key"
+	AuthTypeBearer = "bearer"
+	AuthTypeBasic  = "basic"
+)
+
+type AuthConfig struct {
+	Type            string `yaml:"type"`             // api_key, bearer or basic
+	Header          string `yaml:"header"`           // api_key: header with the key
+	QueryParam      string `yaml:"query_param"`      // api_key: query parameter with the key
+	CredentialsFile string `yaml:"credentials_file"` // lines of `name:secret`, for basic auth name is username
+}
+
+type authenticator struct {
+	conf    *AuthConfig
+	secrets map[string]string // name -> secret
+}
+
+func newAuthenticator(conf *AuthConfig) (*authenticator, error) {
+	switch conf.Type {
+	case AuthTypeAPIKey:
+		if conf.Header == "" && conf.QueryParam == "" {
+			return nil, fmt.Errorf("api_key auth requires header or query_param")
+		}
+	case AuthTypeBearer, AuthTypeBasic:
+	default:
+		return nil, fmt.Errorf("unknown auth type: %s", conf.Type)
+	}
+	secrets, err := loadCredentials(conf.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticator{conf: conf, secrets: secrets}, nil
+}
+
+func loadCredentials(path string) (map[string]string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error open credentials file: %w", err)
+	}
+	defer file.Close()
+
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(text, ":")
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("invalid credentials at line %d, expected name:secret", line)
+		}
+		res[name] = secret
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error read credentials file: %w", err)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("credentials file is empty")
+	}
+	return res, nil
+}
+
+// authenticate returns name of the matched credentials and strips them from the request,
+// so client secrets are not leaked to the upstream
+func (a *authenticator) authenticate(req *http.Request) (string, bool) {
+	switch a.conf.Type {
+	case AuthTypeAPIKey:
+		key := ""
+		if a.conf.Header != "" {
+			key = req.Header.Get(a.conf.Header)
+			req.Header.Del(a.conf.Header)
+		}
+		if a.conf.QueryParam != "" {
+			query := req.URL.Query()
+			if key == "" {
+				key = query.Get(a.conf.QueryParam)
+			}
+			if query.Has(a.conf.QueryParam) {
+				query.Del(a.conf.QueryParam)
+				req.URL.RawQuery = query.Encode()
+			}
+		}
+		return a.matchSecret(key)
+	case AuthTypeBearer:
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		req.Header.Del("Authorization")
+		if !ok {
+			return "", false
+		}
+		return a.matchSecret(token)
+	case AuthTypeBasic:
+		user, password, ok := req.BasicAuth()
+		req.Header.Del("Authorization")
+		if !ok {
+			return "", false
+		}
+		return user, a.checkPassword(user, password)
+	}
+	return "", false
+}
+
+// checkPassword verifies username and password, used by basic auth and forward proxy modes
+func (a *authenticator) checkPassword(user, password string) bool {
+	secret, found := a.secrets[user]
+	return found && subtle.ConstantTimeCompare([]byte(secret), []byte(password)) == 1
+}
+
+func (a *authenticator) matchSecret(secret string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	matched := ""
+	for name, val := range a.secrets { // check all keys to not leak position of the match
+		if subtle.ConstantTimeCompare([]byte(val), []byte(secret)) == 1 {
+			matched = name
+		}
+	}
+	return matched, matched != ""
+}
+
+func (a *authenticator) challenge() string {
+	switch a.conf.Type {
+	case AuthTypeBasic:
+		return `Basic realm="tcp_proxy"`
+	case AuthTypeBearer:
+		return "Bearer"
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceAuth(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	apiKey := uuid.NewString()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("X-Api-Key"), "client key must not reach upstream")
+		require.Empty(t, r.Header.Get("Authorization"), "client credentials must not reach upstream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentials, []byte(fmt.Sprintf("# consumers\nteam-a:%s\nteam-b:%s\n", apiKey, uuid.NewString())), 0o600))
+
+	var authFailures atomic.Int64 // failures can be split between dump cycles
+	container.SrvNotificatorMock.EXPECT().SendInfoAuthFailed("127.0.0.1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_, _ string, counts int) error {
+			authFailures.Add(int64(counts))
+			return nil
+		}).MinTimes(1)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, "team-a", n.ClientKey)
+			return nil
+		}).Times(1)
+
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+		Auth: &proxier.AuthConfig{
+			Type:            proxier.AuthTypeAPIKey,
+			Header:          "X-Api-Key",
+			CredentialsFile: credentials,
+		},
+	}
+	srvProxy := startProxy(t, container, cfg)
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/eth/blocks", cfg.ListenPort)
+
+	t.Run("missing key", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, doRequest(t, proxyURL, nil))
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, doRequest(t, proxyURL, map[string]string{"X-Api-Key": uuid.NewString()}))
+	})
+	t.Run("valid key", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, doRequest(t, proxyURL, map[string]string{"X-Api-Key": apiKey}))
+	})
+	srvProxy.Stop()
+	require.Equal(t, int64(2), authFailures.Load())
+}
+
+func doRequest(t *testing.T, targetURL string, headers map[string]string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, targetURL, http.NoBody)
+	require.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode
+}
+
+func TestServiceAuthFailuresBounded(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentials, []byte("team-a:"+uuid.NewString()+"\n"), 0o600))
+	container.SrvNotificatorMock.EXPECT().SendInfoAuthFailed(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		MaxTrackedEvents:   1,
+		Auth:               &proxier.AuthConfig{Type: proxier.AuthTypeAPIKey, Header: "X-Api-Key", CredentialsFile: credentials},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyAddr := fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort)
+	requestFrom := func(localIP string) (int, error) {
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(localIP)}}
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true, DialContext: dialer.DialContext}}
+		resp, err := client.Get("http://" + proxyAddr + "/")
+		if err != nil {
+			return 0, err
+		}
+		return resp.StatusCode, resp.Body.Close()
+	}
+
+	// when
+	require.Eventually(t, func() bool { // failures of both ips must meet in one dump cycle
+		for _, ip := range []string{"127.0.0.2", "127.0.0.3"} {
+			if code, err := requestFrom(ip); err != nil || code != http.StatusUnauthorized {
+				return false
+			}
+		}
+		return strings.Contains(logs.String(), `"short_message":"got untracked failed authentication attempts"`)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// then
+	require.Contains(t, logs.String(), `"_evicted_ips":"`)
+	require.Contains(t, logs.String(), `"_max_tracked_events":"1"`)
+}
@@ -0,0 +1,458 @@
+package proxier
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	defaultCaptureMaxBytes = 100 << 20
+	defaultCaptureDuration = 10 * time.Minute
+	defaultCaptureTrigger  = 5 * time.Second
+
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterface      = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+	pcapngLinkTypeRaw    = 101 // packets start with IPv4 or IPv6 header
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+
+	captureMaxPayload = 65535 - 40 - 20 // fits into IPv6 packet with TCP header
+)
+
+var errCaptureLimit = errors.New("capture size limit reached")
+
+// CaptureConfig writes both directions of matching connections to pcapng file with synthesized TCP/IP headers.
+// bytes are captured after PROXY header and TLS termination, so payload is what destination gets
+type CaptureConfig struct {
+	Dir         string        `yaml:"dir"`
+	ClientCIDRs []string      `yaml:"client_cidrs"` // all clients if empty
+	MaxBytes    int64         `yaml:"max_bytes"`    // file size cap, 100MB by default
+	Duration    time.Duration `yaml:"duration"`     // capture stops after, 10m by default
+
+	TriggerFile     string        `yaml:"trigger_file"`     // capture runs while file exists, touch restarts it. started with service if empty
+	TriggerInterval time.Duration `yaml:"trigger_interval"` // how often trigger file is checked, 5s by default
+}
+
+func (c *CaptureConfig) triggerInterval() time.Duration {
+	if c.TriggerInterval <= 0 {
+		return defaultCaptureTrigger
+	}
+	return c.TriggerInterval
+}
+
+type capture struct {
+	log   logger.AppLogger
+	nets  []*net.IPNet
+	path  string
+	limit int64
+	timer *time.Timer
+
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	written int64
+	closed  bool
+}
+
+type captureStream struct {
+	capture     *capture
+	client      net.TCPAddr
+	proxy       net.TCPAddr
+	clientSeq   uint32
+	proxySeq    uint32
+	established bool
+}
+
+// StartCapture begins capture of new connections, running capture is replaced. returns path of pcapng file
+func (s *Service) StartCapture(conf *CaptureConfig) (string, error) {
+	nets := make([]*net.IPNet, 0, len(conf.ClientCIDRs))
+	for _, cidr := range conf.ClientCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("error parse capture cidr: %w", err)
+		}
+		nets = append(nets, ipNet)
+	}
+	if err := os.MkdirAll(conf.Dir, 0o750); err != nil {
+		return "", fmt.Errorf("error create capture dir: %w", err)
+	}
+	name := fmt.Sprintf("capture-%d-%s.pcapng", s.conf.ListenPort, time.Now().UTC().Format("20060102T150405.000"))
+	path := filepath.Join(conf.Dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return "", fmt.Errorf("error create capture file: %w", err)
+	}
+	c := &capture{
+		log:   s.log.With(logger.WithString("capture_file", path)),
+		nets:  nets,
+		path:  path,
+		limit: conf.MaxBytes,
+		file:  file,
+		w:     bufio.NewWriter(file),
+	}
+	if c.limit <= 0 {
+		c.limit = defaultCaptureMaxBytes
+	}
+	if err = c.writeHeader(); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	duration := conf.Duration
+	if duration <= 0 {
+		duration = defaultCaptureDuration
+	}
+	c.timer = time.AfterFunc(duration, func() {
+		if s.capture.CompareAndSwap(c, nil) {
+			c.close("duration limit reached")
+		}
+	})
+	if prev := s.capture.Swap(c); prev != nil {
+		prev.close("replaced by new capture")
+	}
+	c.log.Info("capture started",
+		logger.WithString("client_cidrs", fmt.Sprint(conf.ClientCIDRs)),
+		logger.WithInt64("max_bytes", c.limit),
+		logger.WithString("duration", duration.String()),
+	)
+	return path, nil
+}
+
+// StopCapture finishes running capture, no-op if there is none
+func (s *Service) StopCapture() {
+	if c := s.capture.Swap(nil); c != nil {
+		c.close("stopped")
+	}
+}
+
+func (s *Service) bgCaptureTrigger() {
+	ticker := time.NewTicker(s.conf.Capture.triggerInterval())
+	defer ticker.Stop()
+	var started time.Time // trigger file state of running capture
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			started = s.checkCaptureTrigger(started)
+		}
+	}
+}
+
+// checkCaptureTrigger starts capture when trigger file appears or changes and stops it when file is removed
+func (s *Service) checkCaptureTrigger(started time.Time) time.Time {
+	modTime, err := latestModTime(s.conf.Capture.TriggerFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.log.Error("failed to check capture trigger", err)
+			return started
+		}
+		if !started.IsZero() {
+			s.StopCapture()
+		}
+		return time.Time{}
+	}
+	if modTime.Equal(started) {
+		return started
+	}
+	if _, err = s.StartCapture(s.conf.Capture); err != nil {
+		s.log.Error("failed to start capture", err) // retry on next check
+		return started
+	}
+	return modTime
+}
+
+// captureConn wraps client connection if running capture matches client address
+func (s *Service) captureConn(c net.Conn) (net.Conn, *captureStream) {
+	cpt := s.capture.Load()
+	if cpt == nil {
+		return c, nil
+	}
+	client, proxy := tcpAddrOf(c.RemoteAddr()), tcpAddrOf(c.LocalAddr())
+	if !cpt.matches(client.IP) {
+		return c, nil
+	}
+	stream := &captureStream{capture: cpt, client: client, proxy: proxy, clientSeq: 1, proxySeq: 1}
+	if err := cpt.open(stream); err != nil {
+		s.stopCaptureOnError(cpt, err)
+		return c, nil
+	}
+	return &capturedConn{Conn: c, stream: stream, service: s}, stream
+}
+
+func (s *Service) stopCaptureOnError(c *capture, err error) {
+	if s.capture.CompareAndSwap(c, nil) {
+		c.close(err.Error())
+	}
+}
+
+func (c *capture) matches(ip net.IP) bool {
+	if len(c.nets) == 0 {
+		return true
+	}
+	for _, ipNet := range c.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *capture) close(reason string) {
+	c.timer.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	err := c.w.Flush()
+	if errC := c.file.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		c.log.Error("failed to close capture file", err)
+	}
+	c.log.Info("capture finished", logger.WithString("reason", reason), logger.WithInt64("bytes", c.written))
+}
+
+// open synthesizes three-way handshake, so analyzers follow the stream from the start
+func (c *capture) open(st *captureStream) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st.clientSeq--
+	if err := c.writeSegment(st, true, tcpFlagSYN, nil); err != nil {
+		return err
+	}
+	st.clientSeq++
+	st.proxySeq--
+	if err := c.writeSegment(st, false, tcpFlagSYN|tcpFlagACK, nil); err != nil {
+		return err
+	}
+	st.proxySeq++
+	st.established = true
+	return c.writeSegment(st, true, tcpFlagACK, nil)
+}
+
+func (st *captureStream) data(fromClient bool, payload []byte) error {
+	c := st.capture
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(payload) > 0 {
+		chunk := payload[:min(len(payload), captureMaxPayload)]
+		if err := c.writeSegment(st, fromClient, tcpFlagPSH|tcpFlagACK, chunk); err != nil {
+			return err
+		}
+		if fromClient {
+			st.clientSeq += uint32(len(chunk))
+		} else {
+			st.proxySeq += uint32(len(chunk))
+		}
+		payload = payload[len(chunk):]
+	}
+	return nil
+}
+
+func (st *captureStream) close() {
+	c := st.capture
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !st.established {
+		return
+	}
+	st.established = false
+	_ = c.writeSegment(st, true, tcpFlagFIN|tcpFlagACK, nil)
+	st.clientSeq++
+	_ = c.writeSegment(st, false, tcpFlagFIN|tcpFlagACK, nil)
+	st.proxySeq++
+	_ = c.writeSegment(st, true, tcpFlagACK, nil)
+}
+
+// writeSegment appends TCP segment as enhanced packet block, called under c.mu
+func (c *capture) writeSegment(st *captureStream, fromClient bool, flags byte, payload []byte) error {
+	if c.closed {
+		return nil
+	}
+	src, dst, seq, ack := st.client, st.proxy, st.clientSeq, st.proxySeq
+	if !fromClient {
+		src, dst, seq, ack = st.proxy, st.client, st.proxySeq, st.clientSeq
+	}
+	if flags&tcpFlagACK == 0 {
+		ack = 0
+	}
+	packet := buildTCPPacket(src, dst, seq, ack, flags, payload)
+
+	now := time.Now().UnixMicro()
+	padded := (len(packet) + 3) &^ 3
+	blockLen := 32 + padded
+	if c.written+int64(blockLen) > c.limit {
+		return errCaptureLimit
+	}
+	block := make([]byte, blockLen)
+	binary.LittleEndian.PutUint32(block[0:], pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint32(block[8:], 0) // interface id
+	binary.LittleEndian.PutUint32(block[12:], uint32(now>>32))
+	binary.LittleEndian.PutUint32(block[16:], uint32(now))
+	binary.LittleEndian.PutUint32(block[20:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(block[24:], uint32(len(packet)))
+	copy(block[28:], packet)
+	binary.LittleEndian.PutUint32(block[blockLen-4:], uint32(blockLen))
+	return c.write(block)
+}
+
+// writeHeader starts file with section header and single raw IP interface, timestamps are microseconds by default
+func (c *capture) writeHeader() error {
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngSectionHeader)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1) // version 1.0
+	binary.LittleEndian.PutUint16(shb[14:], 0)
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0)) // section length is not specified
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb[0:], pcapngInterface)
+	binary.LittleEndian.PutUint32(idb[4:], 20)
+	binary.LittleEndian.PutUint16(idb[8:], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[12:], 0) // no snap length limit
+	binary.LittleEndian.PutUint32(idb[16:], 20)
+	if err := c.write(append(shb, idb...)); err != nil {
+		return fmt.Errorf("error write capture header: %w", err)
+	}
+	return nil
+}
+
+func (c *capture) write(b []byte) error {
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	c.written += int64(len(b))
+	return nil
+}
+
+// buildTCPPacket synthesizes IPv4 or IPv6 packet with TCP segment and valid checksums
+func buildTCPPacket(src, dst net.TCPAddr, seq, ack uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // header length in words
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[20:], payload)
+
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	if src4 != nil && dst4 != nil {
+		pseudo := make([]byte, 0, 12)
+		pseudo = append(pseudo, src4...)
+		pseudo = append(pseudo, dst4...)
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+		binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+		ip := make([]byte, 20, 20+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8], ip[9] = 64, 6                       // ttl, tcp
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(nil, ip))
+		return append(ip, tcp...)
+	}
+	src16, dst16 := src.IP.To16(), dst.IP.To16()
+	pseudo := make([]byte, 0, 40)
+	pseudo = append(pseudo, src16...)
+	pseudo = append(pseudo, dst16...)
+	pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(tcp)))
+	pseudo = append(pseudo, 0, 0, 0, 6)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	ip := make([]byte, 40, 40+len(tcp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+	ip[6], ip[7] = 6, 64 // tcp, hop limit
+	copy(ip[8:], src16)
+	copy(ip[24:], dst16)
+	return append(ip, tcp...)
+}
+
+// checksum is internet checksum of concatenated parts, first part has even length
+func checksum(pseudo, data []byte) uint16 {
+	var sum uint32
+	for _, part := range [][]byte{pseudo, data} {
+		for i := 0; i+1 < len(part); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(part[i:]))
+		}
+		if len(part)%2 == 1 {
+			sum += uint32(part[len(part)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// tcpAddrOf returns address usable in synthesized packets, unix sockets get loopback with zero port
+func tcpAddrOf(addr net.Addr) net.TCPAddr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return *tcpAddr
+	}
+	if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			p, _ := strconv.Atoi(port)
+			return net.TCPAddr{IP: ip, Port: p}
+		}
+	}
+	return net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// capturedConn copies bytes of both directions into capture
+type capturedConn struct {
+	net.Conn
+	stream  *captureStream
+	service *Service
+}
+
+func (c *capturedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *capturedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(true, p[:n])
+	}
+	return n, err
+}
+
+func (c *capturedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.record(false, p[:n])
+	}
+	return n, err
+}
+
+func (c *capturedConn) record(fromClient bool, p []byte) {
+	if err := c.stream.data(fromClient, p); err != nil {
+		c.service.stopCaptureOnError(c.stream.capture, err)
+	}
+}
@@ -0,0 +1,208 @@
+package proxier_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceCapture(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "captured response")
+	}))
+	t.Cleanup(upstream.Close)
+	table := []struct {
+		name        string
+		clientCIDRs []string
+		matched     bool
+	}{
+		{name: "matching client", clientCIDRs: []string{"127.0.0.0/8"}, matched: true},
+		{name: "other clients", clientCIDRs: []string{"10.0.0.0/8"}},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &proxier.Config{
+				ListenPort:         test_utils.GetFreePort(t),
+				DestinationAddress: "127.0.0.1",
+				DestinationPort:    upstreamPort(t, upstream),
+				Capture:            &proxier.CaptureConfig{Dir: dir, ClientCIDRs: tc.clientCIDRs},
+			}
+			srvProxy := startProxy(t, container, cfg)
+
+			// when
+			code, body := plainGet(t, fmt.Sprintf("http://127.0.0.1:%d/eth/", cfg.ListenPort))
+			srvProxy.StopCapture()
+
+			// then
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, "captured response", body)
+			files, err := filepath.Glob(filepath.Join(dir, "*.pcapng"))
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+			packets := readPcapng(t, files[0])
+			if !tc.matched {
+				require.Empty(t, packets)
+				return
+			}
+			var request, response, syn, fin bool
+			for _, packet := range packets {
+				require.Equal(t, byte(0x45), packet[0], "ipv4 without options")
+				require.Equal(t, uint16(0), ipChecksum(packet[:20]), "valid ip header checksum")
+				flags, payload := packet[20+13], packet[40:]
+				syn = syn || flags == 0x02
+				fin = fin || flags&0x01 != 0
+				request = request || bytes.HasPrefix(payload, []byte("GET /eth/ HTTP/1.1"))
+				response = response || bytes.Contains(payload, []byte("captured response"))
+			}
+			require.True(t, syn, "handshake is synthesized")
+			require.True(t, request, "client bytes are captured")
+			require.True(t, response, "destination bytes are captured")
+			require.True(t, fin, "close is synthesized")
+		})
+	}
+}
+
+func TestServiceCaptureTriggerFile(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "captured response")
+	}))
+	t.Cleanup(upstream.Close)
+	dir, trigger := t.TempDir(), filepath.Join(t.TempDir(), "capture")
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		Capture:            &proxier.CaptureConfig{Dir: dir, TriggerFile: trigger, TriggerInterval: 20 * time.Millisecond},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+	files := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pcapng"))
+		require.NoError(t, err)
+		return files
+	}
+	require.Empty(t, files(), "capture waits for trigger file")
+
+	// when
+	require.NoError(t, os.WriteFile(trigger, nil, 0o600))
+	require.Eventually(t, func() bool { return len(files()) == 1 }, 2*time.Second, 20*time.Millisecond)
+	code, _ := plainGet(t, proxyURL)
+	require.NoError(t, os.Remove(trigger))
+
+	// then
+	require.Equal(t, http.StatusOK, code)
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"_reason":"stopped"`)
+	}, 2*time.Second, 20*time.Millisecond, "removed trigger file stops capture")
+	require.NotEmpty(t, readPcapng(t, files()[0]))
+}
+
+func TestServiceCaptureSizeLimit(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// when
+	path, err := srvProxy.StartCapture(&proxier.CaptureConfig{Dir: t.TempDir(), MaxBytes: 4096, Duration: time.Minute})
+	require.NoError(t, err)
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort), "text/plain", strings.NewReader(strings.Repeat("x", 64<<10)))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// then
+	require.Equal(t, http.StatusOK, resp.StatusCode, "capture limit does not break proxying")
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "capture size limit reached")
+	}, time.Second, 20*time.Millisecond)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.LessOrEqual(t, info.Size(), int64(4096))
+	require.NotEmpty(t, readPcapng(t, path), "packets before the limit are kept")
+}
+
+// readPcapng returns packets of enhanced packet blocks, checks section header and raw IP interface
+func readPcapng(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var packets [][]byte
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 12)
+		blockType, blockLen := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		require.LessOrEqual(t, int(blockLen), len(data))
+		require.Equal(t, blockLen, binary.LittleEndian.Uint32(data[blockLen-4:]), "trailing block length")
+		switch blockType {
+		case 0x0a0d0d0a:
+			require.Equal(t, uint32(0x1a2b3c4d), binary.LittleEndian.Uint32(data[8:]))
+		case 1:
+			require.Equal(t, uint16(101), binary.LittleEndian.Uint16(data[8:]), "raw ip link type")
+		case 6:
+			captured := binary.LittleEndian.Uint32(data[20:])
+			packets = append(packets, data[28:28+captured])
+		}
+		data = data[blockLen:]
+	}
+	return packets
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,263 @@
+package proxier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	DetectedTLS       = "tls"
+	DetectedSSH       = "ssh"
+	DetectedRedis     = "redis"
+	DetectedPostgres  = "postgres"
+	DetectedMySQL     = "mysql"
+	DetectedWebSocket = "websocket"
+	DetectedJSONRPC   = "jsonrpc"
+	DetectedHTTP      = "http"
+	DetectedH2C       = "h2c"
+	DetectedUnknown   = "unknown"
+
+	defaultSniffTimeout = 300 * time.Millisecond
+	detectSnippetLen    = 32 // first bytes shown in logs and notifications
+)
+
+// Detector recognizes protocol by first bytes of connection
+type Detector interface {
+	Name() string
+	// ServerFirst is set for protocols where client is silent until greeting of destination,
+	// such detectors get first bytes of destination instead of client
+	ServerFirst() bool
+	// Detect gets bytes available so far, it should not expect whole message
+	Detect(data []byte) bool
+}
+
+// DetectConfig selects what to do with protocols recognized on connection,
+// lists contain detector names, unknown or * for any protocol
+type DetectConfig struct {
+	SniffTimeout time.Duration `yaml:"sniff_timeout"` // wait for first bytes of client, silent client means server first protocol
+	Allow        []string      `yaml:"allow"`         // any protocol if empty
+	Log          []string      `yaml:"log"`
+	Notify       []string      `yaml:"notify"`
+}
+
+type funcDetector struct {
+	name        string
+	serverFirst bool
+	detect      func(data []byte) bool
+}
+
+func (d *funcDetector) Name() string            { return d.name }
+func (d *funcDetector) ServerFirst() bool       { return d.serverFirst }
+func (d *funcDetector) Detect(data []byte) bool { return d.detect(data) }
+
+// NewDetector builds detector from function
+func NewDetector(name string, serverFirst bool, detect func(data []byte) bool) Detector {
+	return &funcDetector{name: name, serverFirst: serverFirst, detect: detect}
+}
+
+var (
+	detectorsMu sync.RWMutex
+	detectors   = []Detector{ // order matters, websocket is http as well
+		NewDetector(DetectedTLS, false, isTLSRecord),
+		NewDetector(DetectedSSH, false, isSSHBanner),
+		NewDetector(DetectedPostgres, false, isPostgresStartup),
+		NewDetector(DetectedRedis, false, isRedisCommand),
+		NewDetector(DetectedH2C, false, isH2Preface),
+		NewDetector(DetectedWebSocket, false, isWebSocketUpgrade),
+		NewDetector(DetectedHTTP, false, hasHTTPMethodPrefix),
+		NewDetector(DetectedJSONRPC, false, isJSONRPC),
+		NewDetector(DetectedMySQL, true, isMySQLGreeting),
+	}
+)
+
+// RegisterDetector adds detector to all services, registered detectors are checked before built-in ones
+func RegisterDetector(d Detector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	detectors = append([]Detector{d}, detectors...)
+}
+
+func detectProtocol(data []byte, serverFirst bool) string {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	for _, d := range detectors {
+		if d.ServerFirst() == serverFirst && d.Detect(data) {
+			return d.Name()
+		}
+	}
+	return DetectedUnknown
+}
+
+// peekFirstBytes waits for first bytes up to timeout, returns nil if peer is silent
+func peekFirstBytes(c net.Conn, br *bufio.Reader, timeout time.Duration) ([]byte, error) {
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+	if _, err := br.Peek(1); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return br.Peek(br.Buffered())
+}
+
+// serveDetected recognizes protocol before forwarding, returns false if connection is handled already.
+// server first protocols are detected by greeting of destination, so connection is forwarded here
+func (s *Service) serveDetected(l logger.AppLogger, c net.Conn, br *bufio.Reader) (logger.AppLogger, bool) {
+	timeout := s.conf.Detect.SniffTimeout
+	if timeout <= 0 {
+		timeout = defaultSniffTimeout
+	}
+	data, err := peekFirstBytes(c, br, timeout)
+	if err != nil {
+		l.Error("failed to read first bytes", err)
+		return l, false
+	}
+	if data == nil && !s.serverFirstAllowed() {
+		// client of http aware proxy speaks first, forwarding silent one would skip auth and other checks
+		if _, err = br.Peek(1); err != nil {
+			return l, false
+		}
+		data, _ = br.Peek(br.Buffered())
+	}
+	if data != nil {
+		var ok bool
+		l, ok = s.admitProtocol(l, c, detectProtocol(data, false), data)
+		return l, ok
+	}
+
+	server, err := s.dialDestination(s.upstream, c)
+	if err != nil {
+		s.handleDialFailure(l, s.upstream, err)
+		return l, false
+	}
+	defer server.Close()
+	serverBr := bufio.NewReaderSize(server, sniffBufferSize)
+	greeting, err := peekFirstBytes(server, serverBr, timeout)
+	if err != nil {
+		l.Error("failed to read greeting of remote server", err)
+		return l, false
+	}
+	var ok bool
+	if l, ok = s.admitProtocol(l, c, detectProtocol(greeting, true), greeting); ok {
+		pipe(c, br, server, serverBr)
+	}
+	return l, false
+}
+
+// serverFirstAllowed reports whether silent client may be forwarded as server first protocol.
+// http aware proxy does it only if allow list names server first detector explicitly
+func (s *Service) serverFirstAllowed() bool {
+	if !s.httpAware() {
+		return true
+	}
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	for _, d := range detectors {
+		if d.ServerFirst() && slices.Contains(s.conf.Detect.Allow, d.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// admitProtocol applies log, notify and allow lists to detected protocol
+func (s *Service) admitProtocol(l logger.AppLogger, c net.Conn, protocol string, data []byte) (logger.AppLogger, bool) {
+	conf := s.conf.Detect
+	allowed := len(conf.Allow) == 0 || matchProtocol(conf.Allow, protocol)
+	snippet := hex.EncodeToString(data[:min(len(data), detectSnippetLen)])
+	if matchProtocol(conf.Log, protocol) {
+		l = l.With(logger.WithString("detected_protocol", protocol))
+		l.Info("detected protocol", logger.WithString("first_bytes", snippet))
+	}
+	status := "allowed"
+	if !allowed {
+		status = "rejected"
+		l.Info("rejected protocol not in allow list",
+			logger.WithString("detected_protocol", protocol),
+			logger.WithString("first_bytes", snippet),
+		)
+	}
+	if matchProtocol(conf.Notify, protocol) {
+		s.trackEvent(&entities.Notification{
+			Protocol:    entities.ProtocolDetected,
+			RemoteIP:    c.RemoteAddr().String(),
+			Method:      protocol,
+			Body:        snippet,
+			Destination: s.upstream.addr,
+			Status:      status,
+		})
+	}
+	return l, allowed
+}
+
+func matchProtocol(list []string, protocol string) bool {
+	return slices.Contains(list, "*") || slices.Contains(list, protocol)
+}
+
+func isTLSRecord(b []byte) bool {
+	return len(b) >= 3 && b[0] == tlsRecordHandshake && b[1] == 0x03 && b[2] <= 0x04
+}
+
+func isSSHBanner(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("SSH-"))
+}
+
+// isPostgresStartup matches StartupMessage of protocol 3.0 and SSL, GSS or cancel requests sent instead
+func isPostgresStartup(b []byte) bool {
+	if len(b) < 8 {
+		return false
+	}
+	length := binary.BigEndian.Uint32(b)
+	if length < 8 || length > 10_000 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(b[4:]) {
+	case 196608, 80877102, 80877103, 80877104: // 3.0, cancel, ssl, gss encryption
+		return true
+	}
+	return false
+}
+
+// isRedisCommand matches RESP array of bulk strings, clients send commands this way
+func isRedisCommand(b []byte) bool {
+	if len(b) < 4 || b[0] != '*' || b[1] < '0' || b[1] > '9' {
+		return false
+	}
+	return bytes.Contains(b, []byte("\r\n$"))
+}
+
+func isH2Preface(b []byte) bool {
+	return bytes.HasPrefix(b, h2Preface)
+}
+
+func isWebSocketUpgrade(b []byte) bool {
+	if !bytes.HasPrefix(b, []byte("GET ")) {
+		return false
+	}
+	head, _, _ := bytes.Cut(b, []byte("\r\n\r\n"))
+	return bytes.Contains(bytes.ToLower(head), []byte("\r\nupgrade: websocket"))
+}
+
+func isJSONRPC(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) == 0 || (b[0] != '{' && b[0] != '[') {
+		return false
+	}
+	return bytes.Contains(b, []byte(`"jsonrpc"`))
+}
+
+// isMySQLGreeting matches initial handshake packet of protocol 10 sent by server
+func isMySQLGreeting(b []byte) bool {
+	return len(b) >= 5 && b[3] == 0 && b[4] == 0x0a
+}
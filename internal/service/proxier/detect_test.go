@@ -0,0 +1,221 @@
+package proxier_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceDetectProtocols(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	proxier.RegisterDetector(proxier.NewDetector("custom", false, func(data []byte) bool {
+		return bytes.HasPrefix(data, []byte("CUSTOM/1"))
+	}))
+	postgres := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), 196608)
+	table := map[string][]byte{
+		proxier.DetectedTLS:       {0x16, 0x03, 0x01, 0x00, 0x05, 0x01, 0x00, 0x00, 0x01, 0x00},
+		proxier.DetectedSSH:       []byte("SSH-2.0-OpenSSH_9.6\r\n"),
+		proxier.DetectedRedis:     []byte("*1\r\n$4\r\nPING\r\n"),
+		proxier.DetectedPostgres:  postgres,
+		proxier.DetectedWebSocket: []byte("GET /ws HTTP/1.1\r\nHost: node\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"),
+		proxier.DetectedHTTP:      []byte("GET / HTTP/1.1\r\nHost: node\r\n\r\n"),
+		proxier.DetectedH2C:       []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"),
+		proxier.DetectedJSONRPC:   []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","id":1}` + "\n"),
+		proxier.DetectedUnknown:   []byte("hello"),
+		"custom":                  []byte("CUSTOM/1 hello"),
+	}
+	var (
+		mu       sync.Mutex
+		detected = make(map[string]string)
+	)
+	container.SrvNotificatorMock.EXPECT().SendInfoProtocolDetected(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			detected[n.Method] = n.Status
+			return nil
+		}).MinTimes(1)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    tcpUpstream(t, nil),
+		Detect: &proxier.DetectConfig{
+			Allow:  []string{proxier.DetectedHTTP, proxier.DetectedJSONRPC},
+			Log:    []string{"*"},
+			Notify: []string{"*"},
+		},
+	}
+	startProxy(t, container, cfg)
+
+	// when
+	for _, payload := range table {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+		require.NoError(t, err)
+		_, err = c.Write(payload)
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = c.Read(make([]byte, 1))
+		require.NoError(t, c.Close())
+	}
+
+	// then
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(detected) == len(table)
+	}, 2*time.Second, 50*time.Millisecond)
+	for protocol := range table {
+		expected := "rejected"
+		if protocol == proxier.DetectedHTTP || protocol == proxier.DetectedJSONRPC {
+			expected = "allowed"
+		}
+		require.Equal(t, expected, detected[protocol], protocol)
+	}
+}
+
+func TestServiceDetectRejectsConnection(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    tcpUpstream(t, []byte("upstream")),
+		Detect:             &proxier.DetectConfig{Allow: []string{proxier.DetectedRedis}},
+	}
+	startProxy(t, container, cfg)
+	exchange := func(payload string) string {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+		require.NoError(t, err)
+		defer c.Close()
+		_, err = c.Write([]byte(payload))
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		data, _ := io.ReadAll(io.LimitReader(c, 8))
+		return string(data)
+	}
+
+	// when
+	allowed := exchange("*1\r\n$4\r\nPING\r\n")
+	rejected := exchange("SSH-2.0-OpenSSH_9.6\r\n")
+
+	// then
+	require.Equal(t, "upstream", allowed)
+	require.Empty(t, rejected, "connection is closed before dialing destination")
+}
+
+func TestServiceDetectServerFirst(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	greeting := append([]byte{0x0a, 0x00, 0x00, 0x00, 0x0a}, "8.0.36\x00"...)
+	greeting[0] = byte(len(greeting) - 4)
+	notified := make(chan *entities.Notification, 1)
+	container.SrvNotificatorMock.EXPECT().SendInfoProtocolDetected(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			notified <- n
+			return nil
+		}).Times(1)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    tcpUpstream(t, greeting),
+		Detect: &proxier.DetectConfig{
+			SniffTimeout: 100 * time.Millisecond,
+			Allow:        []string{proxier.DetectedMySQL},
+			Notify:       []string{proxier.DetectedMySQL},
+		},
+	}
+	startProxy(t, container, cfg)
+
+	// when
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	data := make([]byte, len(greeting))
+	_, err = io.ReadFull(c, data)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, greeting, data, "greeting of destination is forwarded to silent client")
+	select {
+	case n := <-notified:
+		require.Equal(t, proxier.DetectedMySQL, n.Method)
+		require.Equal(t, "allowed", n.Status)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server first protocol was not reported")
+	}
+}
+
+// tcpUpstream accepts connections, writes greeting and discards everything received
+func tcpUpstream(t *testing.T, greeting []byte) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = c.Write(greeting)
+				_, _ = io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestServiceDetectSilentClientAuthenticated(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "secret")
+	}))
+	t.Cleanup(upstream.Close)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentials, []byte("team-a:key\n"), 0o600))
+	container.SrvNotificatorMock.EXPECT().SendInfoAuthFailed(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		Auth:               &proxier.AuthConfig{Type: proxier.AuthTypeAPIKey, Header: "X-Api-Key", CredentialsFile: credentials},
+		Detect:             &proxier.DetectConfig{SniffTimeout: 100 * time.Millisecond},
+	}
+	startProxy(t, container, cfg)
+
+	// when
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	defer c.Close()
+	time.Sleep(300 * time.Millisecond) // longer than sniff timeout
+	_, err = io.WriteString(c, "GET / HTTP/1.1\r\nHost: node\r\n\r\n")
+	require.NoError(t, err)
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+
+	// then
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "silent client is not forwarded as server first protocol")
+}
@@ -0,0 +1,132 @@
+package proxier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+var (
+	// ForwardHandshakeTimeout limits time for request of forward proxy client, idle clients are disconnected
+	ForwardHandshakeTimeout = 10 * time.Second
+
+	errEgressDenied = errors.New("destination is not allowed")
+)
+
+// EgressAllowlist limits destinations requested by clients of forward proxy modes,
+// destination is allowed if its domain or resolved address matches
+type EgressAllowlist struct {
+	CIDRs   []string `yaml:"cidrs"`
+	Domains []string `yaml:"domains"` // exact or wildcard like *.example.com
+	Ports   []int    `yaml:"ports"`   // any port if empty
+}
+
+type egressPolicy struct {
+	nets    []*net.IPNet
+	domains []string
+	ports   map[int]struct{}
+}
+
+func newEgressPolicy(conf *EgressAllowlist) (*egressPolicy, error) {
+	p := &egressPolicy{domains: conf.Domains, ports: make(map[int]struct{}, len(conf.Ports))}
+	for _, cidr := range conf.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("error parse egress cidr: %w", err)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	for _, port := range conf.Ports {
+		p.ports[port] = struct{}{}
+	}
+	return p, nil
+}
+
+// resolve checks destination and returns address to dial, resolved IP is dialed directly,
+// so DNS answer can not change between the check and the connection
+func (p *egressPolicy) resolve(ctx context.Context, host string, port int) (string, error) {
+	if _, ok := p.ports[port]; len(p.ports) > 0 && !ok {
+		return "", fmt.Errorf("%w: port %d", errEgressDenied, port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !p.allowedIP(ip) {
+			return "", fmt.Errorf("%w: %s", errEgressDenied, ip)
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+	}
+	domainAllowed := p.allowedDomain(host)
+	if !domainAllowed && len(p.nets) == 0 {
+		return "", fmt.Errorf("%w: %s", errEgressDenied, host)
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("error resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if domainAllowed || p.allowedIP(ip) {
+			return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errEgressDenied, host)
+}
+
+func (p *egressPolicy) allowedIP(ip net.IP) bool {
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *egressPolicy) allowedDomain(host string) bool {
+	for _, pattern := range p.domains {
+		if matchHostname(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialEgress checks destination requested by client against allowlist and connects to it
+func (s *Service) dialEgress(l logger.AppLogger, client net.Conn, host string, port int) (net.Conn, *upstream, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	addr, err := s.egress.resolve(ctx, host, port)
+	if err != nil {
+		if errors.Is(err, errEgressDenied) {
+			l.Info("rejected destination not in egress allowlist", logger.WithString("reason", err.Error()))
+		} else {
+			l.Error("failed to resolve destination", err)
+		}
+		return nil, nil, err
+	}
+	u := &upstream{addr: addr}
+	server, err := s.dialDestination(u, client)
+	if err != nil {
+		s.handleDialFailure(l, u, err)
+		return nil, nil, err
+	}
+	return server, u, nil
+}
+
+// trackForwardSession aggregates finished tunnel of forward proxy modes
+func (s *Service) trackForwardSession(protocol string, client net.Conn, target, clientKey string, u *upstream, metered *meteredConn) {
+	if !s.conf.NotifyHTTP {
+		return
+	}
+	s.trackEvent(&entities.Notification{
+		Protocol:    protocol,
+		RemoteIP:    client.RemoteAddr().String(),
+		RemoteURL:   target,
+		Method:      "CONNECT",
+		ClientKey:   clientKey,
+		Destination: u.addr,
+		Bytes:       metered.received.Load() + metered.sent.Load(),
+	})
+}
@@ -0,0 +1,97 @@
+package proxier
+
+import (
+	"container/heap"
+	"slices"
+	"tcp_proxy/internal/entities"
+)
+
+const defaultMaxTrackedEvents = 10_000
+
+// eventTracker aggregates notifications between dumps and keeps at most limit keys.
+// when it is full, key with the smallest weight is moved to overflow bucket and newcomer inherits its weight
+// (space-saving), so keys that are hit often are never evicted by flood of unique ones. guarded by Service.mu
+type eventTracker struct {
+	limit   int
+	entries map[string]*trackedEvent
+	byCount trackedHeap // min-heap by weight
+
+	evicted     int // keys moved to overflow bucket since last dump
+	evictedHits int // events of evicted keys
+}
+
+type trackedEvent struct {
+	id    string
+	event *entities.Notification
+	count int // events seen while key is tracked, exact lower bound
+	error int // weight inherited from evicted key, real count is at most count+error
+	index int
+}
+
+func (e *trackedEvent) weight() int {
+	return e.count + e.error
+}
+
+func newEventTracker(limit int) *eventTracker {
+	if limit <= 0 {
+		limit = defaultMaxTrackedEvents
+	}
+	return &eventTracker{
+		limit:   limit,
+		entries: make(map[string]*trackedEvent, min(limit, 1_000)),
+	}
+}
+
+func (t *eventTracker) track(d *entities.Notification) {
+	id := d.NotifyID()
+	if e, ok := t.entries[id]; ok {
+		d.Packets += e.event.Packets // sessions keep totals of all aggregated events
+		d.Bytes += e.event.Bytes
+		e.event = d
+		e.count++
+		heap.Fix(&t.byCount, e.index)
+		return
+	}
+	e := &trackedEvent{id: id, event: d, count: 1}
+	if len(t.entries) >= t.limit {
+		victim := heap.Pop(&t.byCount).(*trackedEvent)
+		delete(t.entries, victim.id)
+		t.evicted++
+		t.evictedHits += victim.count
+		e.error = victim.weight()
+	}
+	t.entries[id] = e
+	heap.Push(&t.byCount, e)
+}
+
+// take returns tracked events from the most frequent and resets tracker
+func (t *eventTracker) take() (events []*trackedEvent, evicted, evictedHits int) {
+	events, evicted, evictedHits = t.byCount, t.evicted, t.evictedHits
+	slices.SortFunc(events, func(a, b *trackedEvent) int { return b.weight() - a.weight() })
+	clear(t.entries)
+	t.byCount, t.evicted, t.evictedHits = nil, 0, 0
+	return events, evicted, evictedHits
+}
+
+type trackedHeap []*trackedEvent
+
+func (h trackedHeap) Len() int           { return len(h) }
+func (h trackedHeap) Less(i, j int) bool { return h[i].weight() < h[j].weight() }
+func (h trackedHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *trackedHeap) Push(x any) {
+	e := x.(*trackedEvent)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *trackedHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
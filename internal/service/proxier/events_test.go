@@ -0,0 +1,76 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceEventsTrackerBounded(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, count int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			counts[n.RemoteURL] += count
+			return nil
+		}).AnyTimes()
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+		MaxTrackedEvents:   3,
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/eth/", cfg.ListenPort)
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(proxyURL + "probe")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// when
+	for i := range 10 { // hot key is hit between unique ones, so it always outweighs them
+		for _, path := range []string{"hot", fmt.Sprintf("unique-%d", i)} {
+			resp, err := http.Get(proxyURL + path)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+		}
+	}
+
+	// then
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts["/eth/hot"] == 10
+	}, 2*time.Second, 50*time.Millisecond, "hot key is never evicted and counted exactly")
+	require.Contains(t, logs.String(), `"short_message":"got untracked events"`)
+	require.Contains(t, logs.String(), `"_max_tracked_events":"3"`)
+}
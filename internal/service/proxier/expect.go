@@ -0,0 +1,94 @@
+package proxier
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	ExpectAny  = "any"
+	ExpectHTTP = "http"
+	ExpectGRPC = "grpc"
+	ExpectTLS  = "tls"
+)
+
+// expectedProtocols lists detected protocols accepted by expect_protocol
+var expectedProtocols = map[string][]string{
+	ExpectHTTP: {DetectedHTTP, DetectedWebSocket},
+	ExpectGRPC: {DetectedH2C},
+	ExpectTLS:  {DetectedTLS},
+}
+
+// expectedMinBytes is how many first bytes classifier needs to recognize expected protocol
+var expectedMinBytes = map[string]int{
+	ExpectHTTP: 4, // method prefix
+	ExpectGRPC: len(h2Preface),
+	ExpectTLS:  3, // record header
+}
+
+type protocolMismatch struct {
+	expected string
+	detected string
+}
+
+func validateExpectProtocol(conf *Config) error {
+	expect := conf.ExpectProtocol
+	if expect == ExpectTLS && conf.TLS != nil {
+		return errors.New("expect_protocol tls can not be used with tls termination, decrypted stream is checked instead")
+	}
+	if _, ok := expectedProtocols[expect]; ok || expect == "" || expect == ExpectAny {
+		return nil
+	}
+	return fmt.Errorf("unknown expected protocol: %s", expect)
+}
+
+// checkExpectedProtocol closes door for scanners, connection is rejected before destination is dialed
+// if first bytes do not match expected protocol. silent client does not match as well
+func (s *Service) checkExpectedProtocol(l logger.AppLogger, c net.Conn, br *bufio.Reader) bool {
+	expect := s.conf.ExpectProtocol
+	if expect == "" || expect == ExpectAny {
+		return true
+	}
+	timeout := defaultSniffTimeout
+	if s.conf.Detect != nil && s.conf.Detect.SniffTimeout > 0 {
+		timeout = s.conf.Detect.SniffTimeout
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+	var data []byte
+	detected := DetectedUnknown
+	for {
+		// first bytes may come in several segments, keep peeking until classifier has enough of them
+		if _, err := br.Peek(len(data) + 1); err != nil {
+			if data == nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				l.Error("failed to read first bytes", err)
+				return false
+			}
+			break
+		}
+		data, _ = br.Peek(br.Buffered())
+		detected = detectProtocol(data, false)
+		if slices.Contains(expectedProtocols[expect], detected) {
+			return true
+		}
+		if len(data) >= expectedMinBytes[expect] {
+			break
+		}
+	}
+	l.Info("rejected protocol mismatch",
+		logger.WithString("expected_protocol", expect),
+		logger.WithString("detected_protocol", detected),
+		logger.WithString("first_bytes", hex.EncodeToString(data[:min(len(data), detectSnippetLen)])),
+	)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolMismatches[protocolMismatch{expected: expect, detected: detected}]++
+	return false
+}
@@ -0,0 +1,87 @@
+package proxier_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceExpectProtocol(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	var dialed atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dialed.Add(1)
+		}
+	}
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		ExpectProtocol:     proxier.ExpectHTTP,
+		Detect:             &proxier.DetectConfig{SniffTimeout: 100 * time.Millisecond},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	go srvProxy.Start()
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+	exploit := []byte("\x03\x00\x00\x13\x0e\xe0\x00\x00\x00\x00\x00\x01\x00\x08\x00\x03\x00\x00\x00")
+
+	// when
+	code, body := plainGet(t, fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort))
+	split, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	_, err = io.WriteString(split, "G") // method prefix comes in several segments
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	_, err = io.WriteString(split, "ET / HTTP/1.1\r\nHost: node\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	_ = split.SetReadDeadline(time.Now().Add(time.Second))
+	splitResp, err := io.ReadAll(split)
+	require.NoError(t, err)
+	require.NoError(t, split.Close())
+	for _, payload := range [][]byte{exploit, nil} { // silent client does not match as well
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+		require.NoError(t, err)
+		_, err = c.Write(payload)
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF, "proxy closes connection")
+		require.NoError(t, c.Close())
+	}
+	srvProxy.Stop()
+
+	// then
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body)
+	require.Contains(t, string(splitResp), "HTTP/1.1 200 OK", "client is classified by all first bytes within sniff timeout")
+	require.Equal(t, int64(2), dialed.Load(), "destination is not dialed for mismatched traffic")
+	require.Contains(t, logs.String(), `"_first_bytes":"`+hex.EncodeToString(exploit))
+	require.Contains(t, logs.String(), `"short_message":"got protocol mismatches"`)
+	require.Contains(t, logs.String(), `"_expected_protocol":"http","_detected_protocol":"unknown","_count":`)
+}
@@ -0,0 +1,371 @@
+package proxier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"tcp_proxy/internal/logger"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	FaultsReloadInterval = 10 * time.Second // how often faults_file is checked for changes
+)
+
+const (
+	FaultLatency      = "latency"
+	FaultJitter       = "jitter"
+	FaultBandwidth    = "bandwidth"
+	FaultReset        = "reset"
+	FaultCloseAfter   = "close_after"
+	FaultHTTPError    = "http_error"
+	FaultJSONRPCError = "jsonrpc_error"
+
+	defaultFaultRPCCode    = -32603
+	defaultFaultRPCMessage = "injected fault"
+)
+
+var errFaultInjected = errors.New("connection broken by fault injection")
+
+// FaultRule breaks traffic on purpose, so clients can be tested against flaky destination.
+// connection faults are rolled once per connection, http faults once per request
+type FaultRule struct {
+	Kind        string   `yaml:"kind"`         // latency, jitter, bandwidth, reset, close_after, http_error or jsonrpc_error
+	Probability *float64 `yaml:"probability"`  // 0..1, always if not set, 0 turns rule off
+	ClientCIDRs []string `yaml:"client_cidrs"` // all clients if empty
+
+	Latency        time.Duration `yaml:"latency"`          // latency: delay of response, jitter: max random delay
+	BytesPerSecond int64         `yaml:"bytes_per_second"` // bandwidth: cap of both directions
+	Bytes          int64         `yaml:"bytes"`            // close_after: bytes sent to client before close
+	Status         int           `yaml:"status"`           // http_error: 503 by default
+	RPCCode        int           `yaml:"rpc_code"`         // jsonrpc_error: -32603 by default
+	RPCMessage     string        `yaml:"rpc_message"`
+}
+
+type faultRule struct {
+	FaultRule
+	nets []*net.IPNet
+}
+
+type faultSet struct {
+	conf  []FaultRule
+	rules []faultRule
+}
+
+// SetFaultRules replaces fault rules of the proxy, new rules apply to new connections and requests
+func (s *Service) SetFaultRules(rules []FaultRule) error {
+	set := &faultSet{conf: append([]FaultRule(nil), rules...), rules: make([]faultRule, 0, len(rules))}
+	for _, rule := range rules {
+		compiled, err := compileFaultRule(rule)
+		if err != nil {
+			return err
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	s.faults.Store(set)
+	s.log.Info("fault rules updated", logger.WithInt("rules", len(rules)))
+	return nil
+}
+
+// FaultRules returns rules in effect
+func (s *Service) FaultRules() []FaultRule {
+	set := s.faults.Load()
+	if set == nil {
+		return nil
+	}
+	return append([]FaultRule(nil), set.conf...)
+}
+
+// faultsFile keeps fault rules in yaml list, same format as `faults` of config
+type faultsFile struct {
+	file    string
+	modTime time.Time // last loaded file state, accessed by single goroutine
+}
+
+// load reads rules if file changed since the previous load, nil rules mean no change
+func (f *faultsFile) load() ([]FaultRule, error) {
+	modTime, err := latestModTime(f.file)
+	if err != nil {
+		return nil, err
+	}
+	if modTime.Equal(f.modTime) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(f.file)
+	if err != nil {
+		return nil, fmt.Errorf("error read faults file: %w", err)
+	}
+	rules := []FaultRule{}
+	if err = yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parse faults file: %w", err)
+	}
+	f.modTime = modTime
+	return rules, nil
+}
+
+func (s *Service) bgReloadFaults() {
+	ticker := time.NewTicker(FaultsReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.reloadFaults()
+		}
+	}
+}
+
+// reloadFaults applies changed faults file, broken file keeps rules in effect
+func (s *Service) reloadFaults() {
+	rules, err := s.faultsFile.load()
+	if err == nil && rules != nil {
+		err = s.SetFaultRules(rules)
+	}
+	if err != nil {
+		s.log.Error("failed to reload fault rules", err)
+	}
+}
+
+func compileFaultRule(rule FaultRule) (faultRule, error) {
+	switch rule.Kind {
+	case FaultLatency, FaultJitter:
+		if rule.Latency <= 0 {
+			return faultRule{}, fmt.Errorf("fault %s requires latency", rule.Kind)
+		}
+	case FaultBandwidth:
+		if rule.BytesPerSecond <= 0 {
+			return faultRule{}, fmt.Errorf("fault %s requires bytes_per_second", rule.Kind)
+		}
+	case FaultCloseAfter:
+		if rule.Bytes <= 0 {
+			return faultRule{}, fmt.Errorf("fault %s requires bytes", rule.Kind)
+		}
+	case FaultHTTPError:
+		if rule.Status == 0 {
+			rule.Status = http.StatusServiceUnavailable
+		}
+		if rule.Status < 400 || rule.Status > 599 {
+			return faultRule{}, fmt.Errorf("fault %s requires error status, got %d", rule.Kind, rule.Status)
+		}
+	case FaultJSONRPCError:
+		if rule.RPCCode == 0 {
+			rule.RPCCode = defaultFaultRPCCode
+		}
+		if rule.RPCMessage == "" {
+			rule.RPCMessage = defaultFaultRPCMessage
+		}
+	case FaultReset:
+	default:
+		return faultRule{}, fmt.Errorf("unknown fault kind: %s", rule.Kind)
+	}
+	if p := rule.Probability; p != nil && (*p < 0 || *p > 1) {
+		return faultRule{}, fmt.Errorf("fault probability must be in 0..1, got %v", *p)
+	}
+	compiled := faultRule{FaultRule: rule}
+	for _, cidr := range rule.ClientCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return faultRule{}, fmt.Errorf("error parse fault cidr: %w", err)
+		}
+		compiled.nets = append(compiled.nets, ipNet)
+	}
+	return compiled, nil
+}
+
+// roll reports whether rule fires for the client this time
+func (r *faultRule) roll(client net.IP) bool {
+	if len(r.nets) > 0 && !slices.ContainsFunc(r.nets, func(ipNet *net.IPNet) bool { return ipNet.Contains(client) }) {
+		return false
+	}
+	return r.Probability == nil || rand.Float64() < *r.Probability //nolint:gosec // no need for crypto random
+}
+
+// injectConnFaults wraps client connection if any of connection faults fires, raw is connection accepted by listener
+func (s *Service) injectConnFaults(l logger.AppLogger, c, raw net.Conn) net.Conn {
+	set := s.faults.Load()
+	if set == nil {
+		return c
+	}
+	fc, client := &faultConn{Conn: c, raw: raw}, tcpAddrOf(c.RemoteAddr()).IP
+	var kinds []string
+	for i := range set.rules {
+		rule := &set.rules[i]
+		if rule.Kind == FaultHTTPError || rule.Kind == FaultJSONRPCError || !rule.roll(client) {
+			continue
+		}
+		kinds = append(kinds, rule.Kind)
+		switch rule.Kind {
+		case FaultLatency:
+			fc.latency += rule.Latency
+		case FaultJitter:
+			fc.jitter += rule.Latency
+		case FaultBandwidth:
+			fc.bandwidth = rule.BytesPerSecond
+		case FaultReset:
+			fc.reset = true
+		case FaultCloseAfter:
+			fc.closeAfter = rule.Bytes
+		}
+	}
+	if len(kinds) == 0 {
+		return c
+	}
+	l.Info("injecting connection faults", logger.WithString("faults", strings.Join(kinds, ",")))
+	return fc
+}
+
+// injectHTTPFault answers request on behalf of destination if http fault fires, returns true if it did
+func (s *Service) injectHTTPFault(l logger.AppLogger, c net.Conn, body []byte) bool {
+	set := s.faults.Load()
+	if set == nil {
+		return false
+	}
+	client := tcpAddrOf(c.RemoteAddr()).IP
+	for i := range set.rules {
+		rule := &set.rules[i]
+		if (rule.Kind != FaultHTTPError && rule.Kind != FaultJSONRPCError) || !rule.roll(client) {
+			continue
+		}
+		l.Info("injected http fault", logger.WithString("fault", rule.Kind))
+		if rule.Kind == FaultHTTPError {
+			_ = writeHTTPError(c, rule.Status, nil)
+			return true
+		}
+		body = jsonRPCErrorBody(body, rule.RPCCode, rule.RPCMessage)
+		_ = writeHTTPResponse(c, http.StatusOK, map[string]string{"Content-Type": "application/json"}, body)
+		return true
+	}
+	return false
+}
+
+// jsonRPCErrorBody answers every call of request with error, batch gets batch of errors
+func jsonRPCErrorBody(body []byte, code int, message string) []byte {
+	type rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	type rpcResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   rpcError        `json:"error"`
+	}
+	type rpcCall struct {
+		ID json.RawMessage `json:"id"`
+	}
+	answer := func(id json.RawMessage) rpcResponse {
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		return rpcResponse{JSONRPC: "2.0", ID: id, Error: rpcError{Code: code, Message: message}}
+	}
+	var out any
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []rpcCall
+		_ = json.Unmarshal(trimmed, &batch)
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, call := range batch {
+			responses = append(responses, answer(call.ID))
+		}
+		out = responses
+	} else {
+		var call rpcCall
+		_ = json.Unmarshal(trimmed, &call)
+		out = answer(call.ID)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// faultConn slows down or breaks client connection, latency is added once per client message
+type faultConn struct {
+	net.Conn
+	raw        net.Conn
+	latency    time.Duration
+	jitter     time.Duration
+	bandwidth  int64
+	reset      bool
+	closeAfter int64
+
+	awaiting atomic.Bool // client sent data, next write is a response
+	written  atomic.Int64
+}
+
+func (c *faultConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if c.bandwidth > 0 {
+		p = p[:min(int64(len(p)), max(c.bandwidth/10, 1))]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.awaiting.Store(true)
+		c.throttle(n)
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.reset {
+		if tcpConn, ok := c.raw.(*net.TCPConn); ok {
+			_ = tcpConn.SetLinger(0) // close sends RST
+		}
+		_ = c.raw.Close()
+		return 0, errFaultInjected
+	}
+	if c.awaiting.Swap(false) {
+		delay := c.latency
+		if c.jitter > 0 {
+			delay += rand.N(c.jitter) //nolint:gosec // no need for crypto random
+		}
+		time.Sleep(delay)
+	}
+	broken := false
+	if c.closeAfter > 0 {
+		remaining := c.closeAfter - c.written.Load()
+		if int64(len(p)) >= remaining {
+			p, broken = p[:max(remaining, 0)], true
+		}
+	}
+	written := 0
+	for len(p) > 0 { // bandwidth cap spreads data over time
+		chunk := p
+		if c.bandwidth > 0 {
+			chunk = p[:min(int64(len(p)), max(c.bandwidth/10, 1))]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		c.written.Add(int64(n))
+		if err != nil {
+			return written, err
+		}
+		c.throttle(n)
+		p = p[n:]
+	}
+	if broken {
+		_ = c.raw.Close()
+		return written, errFaultInjected
+	}
+	return written, nil
+}
+
+func (c *faultConn) throttle(n int) {
+	if c.bandwidth > 0 {
+		time.Sleep(time.Duration(int64(n) * int64(time.Second) / c.bandwidth))
+	}
+}
@@ -0,0 +1,212 @@
+package proxier_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceFaultsHTTP(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+		Faults:             []proxier.FaultRule{{Kind: proxier.FaultHTTPError, Status: http.StatusBadGateway}},
+	}
+	srvProxy := startProxy(t, container, cfg)
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+
+	t.Run("http error", func(t *testing.T) {
+		// when
+		status, _ := plainGet(t, proxyURL)
+
+		// then
+		require.Equal(t, http.StatusBadGateway, status)
+	})
+	t.Run("json-rpc error answers every call of batch", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultJSONRPCError, RPCCode: -32005, RPCMessage: "limit exceeded"}}))
+
+		// when
+		resp, err := http.Post(proxyURL, "application/json", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":"a","method":"eth_blockNumber"}]`))
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// then
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.JSONEq(t, `[
+			{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}},
+			{"jsonrpc":"2.0","id":"a","error":{"code":-32005,"message":"limit exceeded"}}
+		]`, string(data))
+	})
+	t.Run("latency", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultLatency, Latency: 300 * time.Millisecond}}))
+
+		// when
+		startedAt := time.Now()
+		status, body := plainGet(t, proxyURL)
+
+		// then
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "ok", body)
+		require.GreaterOrEqual(t, time.Since(startedAt), 300*time.Millisecond)
+	})
+	t.Run("rules of other clients are skipped", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultHTTPError, ClientCIDRs: []string{"10.0.0.0/8"}}}))
+
+		// when
+		status, body := plainGet(t, proxyURL)
+
+		// then
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "ok", body)
+	})
+	t.Run("zero probability turns rule off", func(t *testing.T) {
+		// given
+		never := 0.0
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultHTTPError, Probability: &never}}))
+
+		// when
+		status, body := plainGet(t, proxyURL)
+
+		// then
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "ok", body)
+	})
+	t.Run("invalid rules are not applied", func(t *testing.T) {
+		// when
+		err := srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultCloseAfter}})
+
+		// then
+		require.Error(t, err)
+		require.Len(t, srvProxy.FaultRules(), 1)
+		require.Equal(t, proxier.FaultHTTPError, srvProxy.FaultRules()[0].Kind)
+	})
+}
+
+func TestServiceFaultsFileReload(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	file := filepath.Join(t.TempDir(), "faults.yml")
+	writeRules := func(rules string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(file, []byte(rules), 0o600))
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+	writeRules("- kind: http_error\n  status: 502\n", time.Now().Add(-time.Minute))
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+		FaultsFile:         file,
+	}
+	srvProxy := startProxy(t, container, cfg)
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+	status, _ := plainGet(t, proxyURL)
+	require.Equal(t, http.StatusBadGateway, status, "rules of file are loaded with service")
+
+	// when
+	writeRules("- kind: http_error\n  status: 503\n", time.Now())
+
+	// then
+	require.Eventually(t, func() bool {
+		status, _ = plainGet(t, proxyURL)
+		return status == http.StatusServiceUnavailable
+	}, 2*time.Second, 20*time.Millisecond, "changed file replaces rules")
+
+	// when
+	writeRules("- kind: close_after\n", time.Now().Add(time.Minute))
+	time.Sleep(4 * proxier.FaultsReloadInterval)
+
+	// then
+	require.Len(t, srvProxy.FaultRules(), 1)
+	require.Equal(t, http.StatusServiceUnavailable, srvProxy.FaultRules()[0].Status, "invalid rules keep rules in effect")
+}
+
+func TestServiceFaultsTCP(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    tcpUpstream(t, []byte("0123456789")),
+	}
+	srvProxy := startProxy(t, container, cfg)
+	proxyAddr := fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort)
+	readAll := func(t *testing.T) ([]byte, error) {
+		t.Helper()
+		c, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		defer c.Close()
+		require.NoError(t, c.SetDeadline(time.Now().Add(2*time.Second)))
+		return io.ReadAll(c)
+	}
+
+	t.Run("close after bytes", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultCloseAfter, Bytes: 4}}))
+
+		// when
+		data, err := readAll(t)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "0123", string(data))
+	})
+	t.Run("reset", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultReset}}))
+
+		// when
+		data, err := readAll(t)
+
+		// then
+		require.True(t, errors.Is(err, syscall.ECONNRESET), "got %v", err)
+		require.Empty(t, data)
+	})
+	t.Run("bandwidth", func(t *testing.T) {
+		// given
+		require.NoError(t, srvProxy.SetFaultRules([]proxier.FaultRule{{Kind: proxier.FaultBandwidth, BytesPerSecond: 20}}))
+
+		// when
+		c, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = c.Close() })
+		startedAt := time.Now()
+		data := make([]byte, 10)
+		_, err = io.ReadFull(c, data)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "0123456789", string(data))
+		require.GreaterOrEqual(t, time.Since(startedAt), 400*time.Millisecond)
+	})
+}
@@ -0,0 +1,29 @@
+package proxier
+
+import "path"
+
+// GRPCAccessConfig lists full gRPC method names like /package.Service/Method,
+// patterns like /package.Service/* are supported
+type GRPCAccessConfig struct {
+	Allow []string `yaml:"allow"` // empty list allows everything not denied
+	Deny  []string `yaml:"deny"`
+}
+
+func (g *GRPCAccessConfig) allowed(method string) bool {
+	if g == nil {
+		return true
+	}
+	if matchAny(g.Deny, method) {
+		return false
+	}
+	return len(g.Allow) == 0 || matchAny(g.Allow, method)
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package proxier_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestServiceGRPCAccess(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	grpcSrv := test_utils.NewTestGRPCServer(t, test_utils.SelfSignedCert(t), false)
+	cfg := generateConfig(t, grpcSrv, test_utils.GetFreePort(t))
+	cfg.NotifyHTTP = false
+	cfg.GRPCAccess = &proxier.GRPCAccessConfig{Deny: []string{test_utils.PingMethod}}
+	startProxy(t, container, cfg)
+
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// when, then
+	payload := generateRandomPayload(t, 32*1024)
+	for i := 0; i < 50; i++ { // dropped payloads must not exhaust connection flow-control window
+		var out wrapperspb.BytesValue
+		err = conn.Invoke(container.Ctx, test_utils.PingMethod, &wrapperspb.BytesValue{Value: payload}, &out)
+		require.Equal(t, codes.PermissionDenied, status.Code(err), "call %d: %v", i, err)
+
+		err = conn.Invoke(container.Ctx, test_utils.EchoMethod, &wrapperspb.BytesValue{Value: payload}, &out)
+		require.NoError(t, err, "allowed call on the same connection must work")
+		require.Equal(t, append([]byte("pong:"), payload...), out.Value)
+	}
+}
+
+func TestServiceGRPCAccessDeniedServerAnswer(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	unknownMethod := "/test.UnknownService/Call"
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    eagerH2Server(t),
+		GRPCAccess:         &proxier.GRPCAccessConfig{Deny: []string{unknownMethod}},
+	}
+	startProxy(t, container, cfg)
+
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// when, then
+	for i := 0; i < 10; i++ {
+		var out wrapperspb.BytesValue
+		err = conn.Invoke(container.Ctx, unknownMethod, &wrapperspb.BytesValue{}, &out)
+		require.Equal(t, codes.PermissionDenied, status.Code(err), "call %d: %v", i, err)
+
+		err = conn.Invoke(container.Ctx, test_utils.EchoMethod, &wrapperspb.BytesValue{Value: []byte("ping")}, &out)
+		require.NoError(t, err, "call %d: answer of server to denied stream must not break the connection", i)
+		require.Equal(t, []byte("pong"), out.Value)
+	}
+}
+
+// eagerH2Server answers every gRPC call as soon as headers arrive, before reset of denied stream is read.
+// unknown methods get trailers-only response with unique grpc-message added to dynamic table of the encoder
+func eagerH2Server(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	reply, err := proto.Marshal(&wrapperspb.BytesValue{Value: []byte("pong")})
+	require.NoError(t, err)
+	message := append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(reply))), reply...)
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, errR := io.ReadFull(c, make([]byte, len(http2.ClientPreface))); errR != nil {
+					return
+				}
+				framer := http2.NewFramer(c, c)
+				framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+				var block bytes.Buffer
+				encoder := hpack.NewEncoder(&block)
+				writeHeaders := func(streamID uint32, endStream bool, fields ...string) error {
+					block.Reset()
+					for i := 0; i < len(fields); i += 2 {
+						_ = encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+					}
+					return framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: block.Bytes(), EndStream: endStream, EndHeaders: true})
+				}
+				if framer.WriteSettings() != nil {
+					return
+				}
+				for calls := 0; ; calls++ {
+					frame, errR := framer.ReadFrame()
+					if errR != nil {
+						return
+					}
+					switch f := frame.(type) {
+					case *http2.SettingsFrame:
+						if !f.IsAck() && framer.WriteSettingsAck() != nil {
+							return
+						}
+					case *http2.PingFrame:
+						if !f.IsAck() && framer.WritePing(true, f.Data) != nil {
+							return
+						}
+					case *http2.MetaHeadersFrame:
+						id := f.StreamID
+						if f.PseudoValue("path") != test_utils.EchoMethod {
+							errR = writeHeaders(id, true, ":status", "200", "content-type", "application/grpc",
+								"grpc-status", "12", "grpc-message", fmt.Sprintf("unknown method, call %d", calls))
+						} else if errR = writeHeaders(id, false, ":status", "200", "content-type", "application/grpc"); errR == nil {
+							if errR = framer.WriteData(id, false, message); errR == nil {
+								errR = writeHeaders(id, true, "grpc-status", "0")
+							}
+						}
+						if errR != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,556 @@
+package proxier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	h2FrameHeaderLen = 9
+
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FramePushPromise  = 0x5
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+
+	h2SettingHeaderTableSize = 0x1
+	h2SettingEnablePush      = 0x2
+	h2DefaultHeaderTableSize = 4096
+	h2MinMaxFrameSize        = 16384 // SETTINGS_MAX_FRAME_SIZE can not be smaller
+
+	h2ErrCodeProtocol = 0x1
+	h2ErrCodeCancel   = 0x8
+
+	grpcStatusPermissionDenied = "7"
+)
+
+var errH2PushRejected = errors.New("server sent PUSH_PROMISE, push is disabled by proxy")
+
+type h2Verdict int
+
+const (
+	h2Forward h2Verdict = iota
+	h2Drop              // frame of denied stream, server headers are still decoded
+	h2Deny              // forward headers to keep HPACK state in sync, then reset the stream
+)
+
+type h2Frame struct {
+	raw      []byte // header and payload, forwarded as is
+	typ      byte
+	flags    byte
+	streamID uint32
+}
+
+func (f *h2Frame) payload() []byte {
+	return f.raw[h2FrameHeaderLen:]
+}
+
+func readH2Frame(r io.Reader, buf []byte) (*h2Frame, []byte, error) {
+	buf = buf[:h2FrameHeaderLen]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, buf, err
+	}
+	length := int(buf[0])<<16 | int(buf[1])<<8 | int(buf[2])
+	if cap(buf) < h2FrameHeaderLen+length {
+		grown := make([]byte, h2FrameHeaderLen+length)
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:h2FrameHeaderLen+length]
+	if _, err := io.ReadFull(r, buf[h2FrameHeaderLen:]); err != nil {
+		return nil, buf, err
+	}
+	return &h2Frame{
+		raw:      buf,
+		typ:      buf[3],
+		flags:    buf[4],
+		streamID: binary.BigEndian.Uint32(buf[5:9]) & 0x7fffffff,
+	}, buf, nil
+}
+
+// serveH2C forwards plaintext HTTP/2 frame by frame and decodes headers of gRPC calls.
+// each direction has own HPACK context, so both of them are decoded to keep dynamic tables in sync.
+// with access rules headers of the server are re-encoded by proxy, so dropped answers to denied streams do not desync the client,
+// otherwise frames are forwarded as is and decoding only observes them
+func (s *Service) serveH2C(l logger.AppLogger, c net.Conn, br *bufio.Reader) {
+	server, err := s.dialDestination(s.upstream, c)
+	if err != nil {
+		s.handleDialFailure(l, s.upstream, err)
+		return
+	}
+	defer server.Close()
+
+	session := &h2Session{
+		srv:        s,
+		log:        l,
+		remoteAddr: c.RemoteAddr().String(),
+		clientCert: peerIdentity(c),
+		clientConn: &lockedWriter{w: c},
+		streams:    make(map[uint32]*h2Stream),
+		client:     newH2Direction(),
+		server:     newH2Direction(),
+		rewrite:    s.conf.GRPCAccess != nil,
+	}
+	if session.rewrite {
+		session.encoder = hpack.NewEncoder(&session.encoded)
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- session.forwardClientFrames(br, server) }()
+	go func() { errCh <- session.forwardServerFrames(bufio.NewReader(server), server) }()
+	if err = <-errCh; err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		l.Error("h2c session finished with error", err)
+	}
+}
+
+type h2Stream struct {
+	path        string
+	contentType string
+	bodyLength  int64
+}
+
+type h2Direction struct {
+	decoder     *hpack.Decoder
+	headerBlock []byte // fragments of header block until END_HEADERS
+	blockType   byte   // HEADERS or PUSH_PROMISE which started the block
+	blockStream uint32
+	blockFlags  byte
+	broken      bool // headers can not be decoded anymore, frames are still forwarded
+}
+
+func newH2Direction() *h2Direction {
+	return &h2Direction{decoder: hpack.NewDecoder(h2DefaultHeaderTableSize, nil)}
+}
+
+type h2Session struct {
+	srv        *Service
+	log        logger.AppLogger
+	remoteAddr string
+	clientCert *entities.ClientCert // identity of client on terminated mutual tls
+	clientConn *lockedWriter        // frames of the server and injected responses are written concurrently
+
+	mu         sync.Mutex
+	streams    map[uint32]*h2Stream // admitted streams of the client until response is finished
+	deniedHigh uint32               // highest denied stream, server may answer reset stream at any time
+	client     *h2Direction         // frames sent by client
+	server     *h2Direction         // frames sent by server
+
+	rewrite   bool           // server headers are re-encoded, only access rules need it
+	encoder   *hpack.Encoder // owns dynamic table of the client decoder
+	encoded   bytes.Buffer
+	reencoded []byte // frames of finished server header block, taken by forwardServerFrames
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+func (h *h2Session) forwardClientFrames(br *bufio.Reader, server io.Writer) error {
+	preface := make([]byte, len(h2Preface))
+	if _, err := io.ReadFull(br, preface); err != nil {
+		return err
+	}
+	if _, err := server.Write(preface); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 16*1024)
+	for {
+		frame, b, err := readH2Frame(br, buf)
+		buf = b
+		if err != nil {
+			return err
+		}
+		verdict, err := h.inspect(frame, true)
+		if err != nil {
+			return err
+		}
+		if verdict == h2Drop {
+			if err = h.returnWindow(h.clientConn, frame); err != nil {
+				return err
+			}
+			continue
+		}
+		out := frame.raw
+		if h.rewrite && frame.typ == h2FrameSettings && frame.flags&h2FlagAck == 0 {
+			out = disablePush(frame) // promised streams would need re-encoding too
+		}
+		if _, err = server.Write(out); err != nil {
+			return err
+		}
+		if verdict == h2Deny {
+			if err = h.denyStream(server, frame.streamID); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *h2Session) forwardServerFrames(br *bufio.Reader, server io.Writer) error {
+	buf := make([]byte, 0, 16*1024)
+	for {
+		frame, b, err := readH2Frame(br, buf)
+		buf = b
+		if err != nil {
+			return err
+		}
+		verdict, err := h.inspect(frame, false)
+		if errors.Is(err, errH2PushRejected) {
+			_ = h.goAway(server, h2ErrCodeProtocol)
+		}
+		if err != nil {
+			return err
+		}
+		if verdict == h2Drop {
+			if err = h.returnWindow(server, frame); err != nil {
+				return err
+			}
+			continue
+		}
+		out := frame.raw
+		if h.rewrite && (frame.typ == h2FrameHeaders || frame.typ == h2FrameContinuation) {
+			if out = h.takeReencoded(); out == nil {
+				continue // header block is not finished yet
+			}
+		}
+		if _, err = h.clientConn.Write(out); err != nil {
+			return err
+		}
+	}
+}
+
+func (h *h2Session) inspect(f *h2Frame, fromClient bool) (h2Verdict, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dir := h.server
+	if fromClient {
+		dir = h.client
+	}
+	if dir.broken { // passive session stops inspecting direction it can not decode
+		return h2Forward, nil
+	}
+	verdict := h2Forward
+	if h.denied(f.streamID) {
+		verdict = h2Drop
+	}
+	switch f.typ {
+	case h2FrameSettings:
+		if f.flags&h2FlagAck == 0 {
+			h.applySettings(f, fromClient)
+		}
+	case h2FrameHeaders:
+		block, ok := headersBlockFragment(f)
+		if !ok {
+			dir.broken = true
+			break
+		}
+		dir.headerBlock = append(dir.headerBlock[:0], block...)
+		dir.blockType, dir.blockStream, dir.blockFlags = f.typ, f.streamID, f.flags
+		if f.flags&h2FlagEndHeaders != 0 {
+			if v := h.decodeHeaders(dir, fromClient); verdict == h2Forward {
+				verdict = v
+			}
+		}
+	case h2FramePushPromise:
+		if h.rewrite {
+			return verdict, errH2PushRejected
+		}
+		block, ok := pushPromiseBlockFragment(f)
+		if !ok {
+			dir.broken = true
+			break
+		}
+		dir.headerBlock = append(dir.headerBlock[:0], block...)
+		dir.blockType, dir.blockStream, dir.blockFlags = f.typ, f.streamID, f.flags
+		if f.flags&h2FlagEndHeaders != 0 {
+			h.decodeHeaders(dir, fromClient)
+		}
+	case h2FrameContinuation:
+		dir.headerBlock = append(dir.headerBlock, f.payload()...)
+		if f.flags&h2FlagEndHeaders != 0 {
+			if v := h.decodeHeaders(dir, fromClient); verdict == h2Forward {
+				verdict = v
+			}
+		}
+	case h2FrameData:
+		if st, ok := h.streams[f.streamID]; ok && fromClient {
+			st.bodyLength += int64(len(f.payload()))
+		}
+		if st, ok := h.streams[f.streamID]; ok && !fromClient && f.flags&h2FlagEndStream != 0 {
+			delete(h.streams, f.streamID) // response finished without trailers, plain h2c
+			h.notify(st, "")
+		}
+	case h2FrameRSTStream:
+		if st, ok := h.streams[f.streamID]; ok {
+			delete(h.streams, f.streamID)
+			h.notify(st, "RST_STREAM")
+		}
+	}
+	if dir.broken && h.rewrite && fromClient {
+		return verdict, fmt.Errorf("failed to decode h2c headers, access rules can not be checked")
+	}
+	if dir.broken && h.rewrite {
+		return verdict, fmt.Errorf("failed to decode h2c headers of server, they can not be re-encoded")
+	}
+	return verdict, nil
+}
+
+// reencode packs decoded server headers with encoder of the proxy, split into frames of minimal allowed size
+func (h *h2Session) reencode(streamID uint32, endStream byte, fields []hpack.HeaderField) {
+	h.encoded.Reset()
+	for _, field := range fields {
+		_ = h.encoder.WriteField(field) // writes to buffer never fail
+	}
+	block := h.encoded.Bytes()
+	out := make([]byte, 0, len(block)+h2FrameHeaderLen*(len(block)/h2MinMaxFrameSize+1))
+	typ, flags := byte(h2FrameHeaders), endStream
+	for {
+		chunk := block[:min(len(block), h2MinMaxFrameSize)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= h2FlagEndHeaders
+		}
+		out = append(out, make([]byte, h2FrameHeaderLen)...)
+		putH2FrameHeader(out[len(out)-h2FrameHeaderLen:], len(chunk), typ, flags, streamID)
+		out = append(out, chunk...)
+		if len(block) == 0 {
+			break
+		}
+		typ, flags = h2FrameContinuation, 0
+	}
+	h.reencoded = out
+}
+
+func (h *h2Session) takeReencoded() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := h.reencoded
+	h.reencoded = nil
+	return out
+}
+
+// denyStream cancels already forwarded stream on the server and answers client with trailers-only response
+func (h *h2Session) denyStream(server io.Writer, streamID uint32) error {
+	rst := make([]byte, h2FrameHeaderLen+4)
+	putH2FrameHeader(rst, 4, h2FrameRSTStream, 0, streamID)
+	binary.BigEndian.PutUint32(rst[h2FrameHeaderLen:], h2ErrCodeCancel)
+	if _, err := server.Write(rst); err != nil {
+		return err
+	}
+
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for _, field := range []hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "application/grpc"},
+		{Name: "grpc-status", Value: grpcStatusPermissionDenied},
+		{Name: "grpc-message", Value: "method is not allowed by proxy"},
+	} {
+		field.Sensitive = true // never indexed, client dynamic table is owned by the session encoder
+		if err := encoder.WriteField(field); err != nil {
+			return err
+		}
+	}
+	headers := make([]byte, h2FrameHeaderLen+block.Len())
+	putH2FrameHeader(headers, block.Len(), h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, streamID)
+	copy(headers[h2FrameHeaderLen:], block.Bytes())
+	_, err := h.clientConn.Write(headers)
+	return err
+}
+
+// goAway closes connection of the peer with error code
+func (h *h2Session) goAway(peer io.Writer, code uint32) error {
+	frame := make([]byte, h2FrameHeaderLen+8)
+	putH2FrameHeader(frame, 8, h2FrameGoAway, 0, 0)
+	binary.BigEndian.PutUint32(frame[h2FrameHeaderLen+4:], code) // no stream of the peer was processed
+	_, err := peer.Write(frame)
+	return err
+}
+
+// disablePush appends SETTINGS_ENABLE_PUSH=0 to settings of the client, the last value of setting wins
+func disablePush(f *h2Frame) []byte {
+	out := make([]byte, len(f.raw), len(f.raw)+6)
+	copy(out, f.raw)
+	out = binary.BigEndian.AppendUint16(out, h2SettingEnablePush)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	putH2FrameHeader(out, len(out)-h2FrameHeaderLen, f.typ, f.flags, f.streamID)
+	return out
+}
+
+// returnWindow gives back connection flow-control window consumed by dropped DATA frame,
+// otherwise the sender stalls once the window is exhausted
+func (h *h2Session) returnWindow(sender io.Writer, f *h2Frame) error {
+	if f.typ != h2FrameData || len(f.payload()) == 0 {
+		return nil
+	}
+	update := make([]byte, h2FrameHeaderLen+4)
+	putH2FrameHeader(update, 4, h2FrameWindowUpdate, 0, 0)
+	binary.BigEndian.PutUint32(update[h2FrameHeaderLen:], uint32(len(f.payload())))
+	_, err := sender.Write(update)
+	return err
+}
+
+func putH2FrameHeader(b []byte, length int, typ, flags byte, streamID uint32) {
+	b[0], b[1], b[2] = byte(length>>16), byte(length>>8), byte(length)
+	b[3], b[4] = typ, flags
+	binary.BigEndian.PutUint32(b[5:9], streamID)
+}
+
+func (h *h2Session) notify(st *h2Stream, grpcStatus string) {
+	if h.srv.conf.NotifyHTTP {
+		h.srv.handleGRPCNotification(h.remoteAddr, h.clientCert, st, grpcStatus)
+	}
+}
+
+// applySettings follows header table size, it limits encoder of the opposite side
+func (h *h2Session) applySettings(f *h2Frame, fromClient bool) {
+	payload := f.payload()
+	for i := 0; i+6 <= len(payload); i += 6 {
+		if binary.BigEndian.Uint16(payload[i:]) != h2SettingHeaderTableSize {
+			continue
+		}
+		size := binary.BigEndian.Uint32(payload[i+2:])
+		if fromClient {
+			h.server.decoder.SetAllowedMaxDynamicTableSize(size)
+			if h.encoder != nil {
+				h.encoder.SetMaxDynamicTableSizeLimit(size)
+			}
+		} else {
+			h.client.decoder.SetAllowedMaxDynamicTableSize(size)
+		}
+	}
+}
+
+func headersBlockFragment(f *h2Frame) ([]byte, bool) {
+	payload := f.payload()
+	padding := 0
+	if f.flags&h2FlagPadded != 0 {
+		if len(payload) < 1 {
+			return nil, false
+		}
+		padding = int(payload[0])
+		payload = payload[1:]
+	}
+	if f.flags&h2FlagPriority != 0 {
+		if len(payload) < 5 {
+			return nil, false
+		}
+		payload = payload[5:]
+	}
+	if padding > len(payload) {
+		return nil, false
+	}
+	return payload[:len(payload)-padding], true
+}
+
+// pushPromiseBlockFragment skips promised stream id, header block of PUSH_PROMISE is encoded with server context
+func pushPromiseBlockFragment(f *h2Frame) ([]byte, bool) {
+	payload := f.payload()
+	padding := 0
+	if f.flags&h2FlagPadded != 0 {
+		if len(payload) < 1 {
+			return nil, false
+		}
+		padding = int(payload[0])
+		payload = payload[1:]
+	}
+	if len(payload) < 4 || padding > len(payload)-4 {
+		return nil, false
+	}
+	return payload[4 : len(payload)-padding], true
+}
+
+// denied reports stream of the client which was not admitted, while ids up to the highest denied one are used
+func (h *h2Session) denied(streamID uint32) bool {
+	if streamID%2 == 0 || streamID > h.deniedHigh { // connection and server initiated streams are never denied
+		return false
+	}
+	_, admitted := h.streams[streamID]
+	return !admitted
+}
+
+func (h *h2Session) decodeHeaders(dir *h2Direction, fromClient bool) h2Verdict {
+	if dir.broken {
+		return h2Forward
+	}
+	fields, err := dir.decoder.DecodeFull(dir.headerBlock)
+	if err != nil {
+		dir.broken = true
+		h.log.Error("failed to decode h2c headers", err)
+		return h2Forward
+	}
+	if dir.blockType == h2FramePushPromise {
+		return h2Forward // request of promised stream only updates dynamic table
+	}
+	streamID := dir.blockStream
+	if fromClient {
+		st := &h2Stream{}
+		for _, field := range fields {
+			switch field.Name {
+			case ":path":
+				st.path = field.Value
+			case "content-type":
+				st.contentType = field.Value
+			}
+		}
+		if st.path == "" {
+			return h2Forward // trailers of the client stream
+		}
+		if !h.srv.conf.GRPCAccess.allowed(st.path) {
+			h.log.Info("grpc call denied", logger.WithString("method", st.path))
+			h.deniedHigh = max(h.deniedHigh, streamID)
+			h.notify(st, grpcStatusPermissionDenied)
+			return h2Deny
+		}
+		h.streams[streamID] = st
+		return h2Forward
+	}
+
+	if h.denied(streamID) { // answer of the server raced with reset, client got response already
+		return h2Drop
+	}
+	if h.rewrite {
+		h.reencode(streamID, dir.blockFlags&h2FlagEndStream, fields)
+	}
+	st, ok := h.streams[streamID]
+	if !ok {
+		return h2Forward
+	}
+	for _, field := range fields {
+		if field.Name == "grpc-status" { // trailers or trailers-only response
+			delete(h.streams, streamID)
+			h.notify(st, field.Value)
+			return h2Forward
+		}
+	}
+	if dir.blockFlags&h2FlagEndStream != 0 { // response finished without grpc status, plain h2c
+		delete(h.streams, streamID)
+		h.notify(st, "")
+	}
+	return h2Forward
+}
@@ -0,0 +1,292 @@
+package proxier_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestServiceH2CPassive(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	var valid bytes.Buffer
+	encoder := hpack.NewEncoder(&valid)
+	_ = encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200", Sensitive: true})
+	blocks := [][]byte{valid.Bytes(), {0x80}} // index 0 is not valid, headers of the server can not be decoded
+	port, _ := rawH2Server(t, func(framer *http2.Framer, streamID uint32, call int) error {
+		if err := framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: blocks[call%len(blocks)], EndHeaders: true}); err != nil {
+			return err
+		}
+		return framer.WriteData(streamID, true, []byte("payload"))
+	})
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    port,
+		NotifyHTTP:         true,
+	}
+	startProxy(t, container, cfg)
+	framer, call := rawH2Client(t, cfg.ListenPort)
+
+	for i, block := range blocks {
+		// when
+		streamID := call("/test.Service/Call")
+
+		// then
+		headers := nextH2Frame[*http2.HeadersFrame](t, framer)
+		require.Equal(t, streamID, headers.StreamID)
+		require.Equal(t, block, headers.HeaderBlockFragment(), "call %d: headers are forwarded as is without access rules", i)
+		data := nextH2Frame[*http2.DataFrame](t, framer)
+		require.Equal(t, "payload", string(data.Data()), "call %d: session survives headers it can not decode", i)
+	}
+}
+
+func TestServiceH2CPushPromise(t *testing.T) {
+	// given
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	encode := func(fields ...string) []byte {
+		block.Reset()
+		for i := 0; i < len(fields); i += 2 {
+			_ = encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+		}
+		return append([]byte(nil), block.Bytes()...)
+	}
+	pushingServer := func(t *testing.T) (int, <-chan http2.ErrCode) {
+		return rawH2Server(t, func(framer *http2.Framer, streamID uint32, _ int) error {
+			// promised request adds grpc-status to dynamic table, response refers to it by index
+			promise := encode(":method", "GET", ":scheme", "http", ":authority", "node", ":path", "/pushed", "grpc-status", "5")
+			half := len(promise) / 2
+			err := framer.WritePushPromise(http2.PushPromiseParam{StreamID: streamID, PromiseID: streamID + 1, BlockFragment: promise[:half]})
+			if err == nil {
+				err = framer.WriteContinuation(streamID, true, promise[half:])
+			}
+			if err == nil {
+				err = framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID: streamID, EndStream: true, EndHeaders: true,
+					BlockFragment: encode(":status", "200", "content-type", "application/grpc", "grpc-status", "5"),
+				})
+			}
+			return err
+		})
+	}
+
+	t.Run("passive session decodes promised request", func(t *testing.T) {
+		// given
+		container := test_utils.GetClean(t)
+		statuses := make(chan string, 1)
+		container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+				statuses <- n.Status
+				return nil
+			}).Times(1)
+		port, _ := pushingServer(t)
+		cfg := &proxier.Config{
+			ListenPort:         test_utils.GetFreePort(t),
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    port,
+			NotifyHTTP:         true,
+		}
+		startProxy(t, container, cfg)
+		framer, call := rawH2Client(t, cfg.ListenPort)
+
+		// when
+		call("/test.Service/Call")
+		nextH2Frame[*http2.HeadersFrame](t, framer)
+
+		// then
+		select {
+		case status := <-statuses:
+			require.Equal(t, "5", status, "status of response is decoded with dynamic table updated by PUSH_PROMISE")
+		case <-time.After(2 * time.Second):
+			t.Fatal("grpc call was not reported")
+		}
+	})
+	t.Run("push is rejected with access rules", func(t *testing.T) {
+		// given
+		container := test_utils.GetClean(t)
+		container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		port, goAway := pushingServer(t)
+		cfg := &proxier.Config{
+			ListenPort:         test_utils.GetFreePort(t),
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    port,
+			GRPCAccess:         &proxier.GRPCAccessConfig{Deny: []string{"/test.Service/Denied"}},
+		}
+		startProxy(t, container, cfg)
+		_, call := rawH2Client(t, cfg.ListenPort)
+
+		// when
+		call("/test.Service/Call")
+
+		// then
+		select {
+		case code := <-goAway:
+			require.Equal(t, http2.ErrCodeProtocol, code)
+		case <-time.After(2 * time.Second):
+			t.Fatal("PUSH_PROMISE was not rejected")
+		}
+	})
+}
+
+func TestServiceH2CLateAnswersOfDeniedStreams(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	const deniedCalls = 150
+	var (
+		block bytes.Buffer
+		held  []uint32
+	)
+	encoder := hpack.NewEncoder(&block)
+	answer := func(framer *http2.Framer, streamID uint32) error {
+		block.Reset()
+		_ = encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+		_ = encoder.WriteField(hpack.HeaderField{Name: "content-type", Value: "application/grpc"})
+		if err := framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: block.Bytes(), EndHeaders: true}); err != nil {
+			return err
+		}
+		return framer.WriteData(streamID, true, []byte("payload"))
+	}
+	port, _ := rawH2Server(t, func(framer *http2.Framer, streamID uint32, call int) error {
+		if call < deniedCalls { // denied streams are answered only after all of them are reset
+			held = append(held, streamID)
+			return nil
+		}
+		for _, id := range held {
+			if err := answer(framer, id); err != nil {
+				return err
+			}
+		}
+		return answer(framer, streamID)
+	})
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    port,
+		GRPCAccess:         &proxier.GRPCAccessConfig{Deny: []string{"/test.Service/Denied"}},
+	}
+	startProxy(t, container, cfg)
+	framer, call := rawH2Client(t, cfg.ListenPort)
+	for range deniedCalls {
+		streamID := call("/test.Service/Denied")
+		require.Equal(t, streamID, nextH2Frame[*http2.HeadersFrame](t, framer).StreamID, "denied call is answered by proxy")
+	}
+
+	// when
+	allowed := call("/test.Service/Allowed")
+
+	// then
+	headers := nextH2Frame[*http2.HeadersFrame](t, framer)
+	require.Equal(t, allowed, headers.StreamID, "late answers of denied streams are dropped")
+	data := nextH2Frame[*http2.DataFrame](t, framer)
+	require.Equal(t, allowed, data.StreamID)
+	require.Equal(t, "payload", string(data.Data()))
+}
+
+// rawH2Server answers every request of h2c client with frames written by answer, call counts requests of the connection.
+// error codes of GOAWAY frames sent by proxy are reported to returned channel
+func rawH2Server(t *testing.T, answer func(framer *http2.Framer, streamID uint32, call int) error) (int, <-chan http2.ErrCode) {
+	t.Helper()
+	goAway := make(chan http2.ErrCode, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, errR := io.ReadFull(c, make([]byte, len(http2.ClientPreface))); errR != nil {
+					return
+				}
+				framer := http2.NewFramer(c, c)
+				if framer.WriteSettings() != nil {
+					return
+				}
+				for calls := 0; ; {
+					frame, errR := framer.ReadFrame()
+					if errR != nil {
+						return
+					}
+					switch f := frame.(type) {
+					case *http2.SettingsFrame:
+						if !f.IsAck() && framer.WriteSettingsAck() != nil {
+							return
+						}
+					case *http2.HeadersFrame:
+						if answer(framer, f.StreamID, calls) != nil {
+							return
+						}
+						calls++
+					case *http2.GoAwayFrame:
+						goAway <- f.ErrCode
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, goAway
+}
+
+// rawH2Client opens h2c connection to proxy, call sends request headers of new stream and returns its id
+func rawH2Client(t *testing.T, port int) (*http2.Framer, func(path string) uint32) {
+	t.Helper()
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	require.NoError(t, c.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(c, http2.ClientPreface)
+	require.NoError(t, err)
+	framer := http2.NewFramer(c, c)
+	framer.AllowIllegalReads = true // framer of go does not expect CONTINUATION of PUSH_PROMISE
+	require.NoError(t, framer.WriteSettings())
+	var (
+		block    bytes.Buffer
+		streamID uint32 = 1
+	)
+	encoder := hpack.NewEncoder(&block)
+	return framer, func(path string) uint32 {
+		block.Reset()
+		for _, field := range []hpack.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "http"},
+			{Name: ":authority", Value: "node"},
+			{Name: ":path", Value: path},
+			{Name: "content-type", Value: "application/grpc"},
+		} {
+			require.NoError(t, encoder.WriteField(field))
+		}
+		id := streamID
+		streamID += 2
+		require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block.Bytes(), EndStream: true, EndHeaders: true}))
+		return id
+	}
+}
+
+// nextH2Frame skips connection frames until frame of expected type
+func nextH2Frame[T http2.Frame](t *testing.T, framer *http2.Framer) T {
+	t.Helper()
+	for {
+		frame, err := framer.ReadFrame()
+		require.NoError(t, err, "%v", framer.ErrorDetail())
+		if f, ok := frame.(T); ok {
+			return f
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package proxier
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+type HeaderRules struct {
+	Set          map[string]string `yaml:"set"`
+	Add          map[string]string `yaml:"add"`
+	Remove       []string          `yaml:"remove"`
+	RewritePath  *PathRewrite      `yaml:"rewrite_path"`
+	ForwardedFor bool              `yaml:"forwarded_for"` // add X-Forwarded-For and X-Real-IP with client address
+}
+
+type PathRewrite struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (r *HeaderRules) enabled() bool {
+	return len(r.Set) > 0 || len(r.Add) > 0 || len(r.Remove) > 0 || r.RewritePath != nil || r.ForwardedFor
+}
+
+// apply modifies request before it is written to the upstream
+func (r *HeaderRules) apply(req *http.Request, remoteAddr string) {
+	for _, key := range r.Remove {
+		req.Header.Del(key)
+	}
+	for key, val := range r.Set {
+		if strings.EqualFold(key, "Host") {
+			req.Host = val // req.Write takes host from here, not from headers
+			continue
+		}
+		req.Header.Set(key, val)
+	}
+	for key, val := range r.Add {
+		req.Header.Add(key, val)
+	}
+	if r.RewritePath != nil && strings.HasPrefix(req.URL.Path, r.RewritePath.From) {
+		req.URL.Path = r.RewritePath.To + strings.TrimPrefix(req.URL.Path, r.RewritePath.From)
+		req.URL.RawPath = ""
+	}
+	if r.ForwardedFor {
+		clientIP := remoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			clientIP = host
+		}
+		forwarded := clientIP
+		if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			forwarded = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.Header.Set("X-Real-IP", clientIP)
+	}
+}
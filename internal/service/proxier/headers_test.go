@@ -0,0 +1,94 @@
+package proxier_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type echoedRequest struct {
+	Host    string
+	Path    string
+	Headers http.Header
+}
+
+func TestServiceHeaderRules(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	apiKey := uuid.NewString()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(echoedRequest{Host: r.Host, Path: r.URL.Path, Headers: r.Header})
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		HeaderRules: proxier.HeaderRules{
+			Set:          map[string]string{"Host": "rpc.provider.local", "X-Api-Key": apiKey},
+			Add:          map[string]string{"X-Proxy": "tcp_proxy"},
+			Remove:       []string{"Cookie"},
+			RewritePath:  &proxier.PathRewrite{From: "/eth/", To: "/v1/" + apiKey + "/"},
+			ForwardedFor: true,
+		},
+	}
+	startProxy(t, container, cfg)
+
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	// when, then
+	for i := 0; i < 3; i++ { // same keep-alive connection, rules apply to every request
+		req, err := http.NewRequestWithContext(container.Ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/eth/blocks", cfg.ListenPort), http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		var echoed echoedRequest
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&echoed))
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, "rpc.provider.local", echoed.Host)
+		require.Equal(t, "/v1/"+apiKey+"/blocks", echoed.Path)
+		require.Equal(t, apiKey, echoed.Headers.Get("X-Api-Key"))
+		require.Equal(t, "tcp_proxy", echoed.Headers.Get("X-Proxy"))
+		require.Empty(t, echoed.Headers.Get("Cookie"))
+		require.Equal(t, "10.0.0.1, 127.0.0.1", echoed.Headers.Get("X-Forwarded-For"))
+		require.Equal(t, "127.0.0.1", echoed.Headers.Get("X-Real-IP"))
+	}
+}
+
+func startProxy(t *testing.T, container *test_utils.TestContainer, cfg *proxier.Config) *proxier.Service {
+	t.Helper()
+	srvProxy := proxier.NewService(container.Ctx, cfg, container.Log, container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+
+	// wait until proxy accepts
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+	return srvProxy
+}
+
+func upstreamPort(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
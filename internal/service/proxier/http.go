@@ -0,0 +1,207 @@
+package proxier
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"tcp_proxy/internal/limiter"
+	"tcp_proxy/internal/logger"
+)
+
+// serveHTTP forwards requests of keep-alive connection one by one,
+// so every request passes authentication, notification and header rules, not only the first one
+func (s *Service) serveHTTP(l logger.AppLogger, c net.Conn, br *bufio.Reader, req *http.Request, body []byte) {
+	upstreams := make(map[*upstream]*upstreamConn, 1) // requests of one connection can be routed to different destinations
+	defer func() {
+		for _, u := range upstreams {
+			_ = u.conn.Close()
+		}
+	}()
+
+	remoteAddr := c.RemoteAddr().String()
+	clientCert := peerIdentity(c)
+	for {
+		clientKey := ""
+		if s.auth != nil {
+			var ok bool
+			if clientKey, ok = s.auth.authenticate(req); !ok {
+				s.handleAuthFailure(l, remoteAddr)
+				_ = writeHTTPError(c, http.StatusUnauthorized, map[string]string{"WWW-Authenticate": s.auth.challenge()})
+				return
+			}
+		}
+		if s.quota != nil {
+			if retryAfter, reason, ok := s.quota.Allow(s.quotaConsumer(clientKey, remoteAddr)); !ok {
+				l.Info("client request rejected", logger.WithString("reason", reason), logger.WithString("client_key", clientKey))
+				_ = writeHTTPError(c, http.StatusTooManyRequests, map[string]string{
+					"Retry-After": strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+				})
+				return
+			}
+		}
+		destination := s.routeDestination(req, body)
+		if s.conf.NotifyHTTP {
+			s.handleHTTPNotification(req, body, remoteAddr, clientKey, clientCert, destination.addr)
+		}
+		if s.injectHTTPFault(l, c, body) {
+			return
+		}
+		rec := s.recordSnapshot(req, body, remoteAddr, destination.addr)
+		mr := s.mirrorSnapshot(req, body, remoteAddr) // header rules of primary destination carry its secrets
+		s.conf.HeaderRules.apply(req, remoteAddr)
+
+		uc, ok := upstreams[destination]
+		if !ok { // dial only after request is accepted
+			server, err := s.dialDestination(destination, c)
+			if err != nil {
+				s.handleDialFailure(l, destination, err)
+				_ = writeHTTPError(c, http.StatusBadGateway, nil)
+				return
+			}
+			uc = &upstreamConn{conn: server, br: bufio.NewReader(server)}
+			upstreams[destination] = uc
+		}
+
+		resp, errR := roundTrip(c, uc.conn, uc.br, req, body)
+		if errR != nil {
+			l.Error("failed to proxy http request", errR)
+			return
+		}
+		if isTunnelResponse(req, resp) {
+			if errR = resp.Write(c); errR != nil {
+				return
+			}
+			if isWebsocketUpgrade(req, resp) {
+				s.pipeWebsocket(l, c, br, uc.conn, uc.br, req, resp)
+				return
+			}
+			pipe(c, br, uc.conn, uc.br)
+			return
+		}
+		s.capturePrimary(mr, resp)
+		s.captureRecorded(rec, resp)
+		errR = resp.Write(c)
+		_ = resp.Body.Close()
+		s.enqueueMirror(mr)
+		s.finishRecord(rec)
+		if errR != nil || req.Close {
+			return
+		}
+		if resp.Close {
+			_ = uc.conn.Close()
+			delete(upstreams, destination)
+		}
+
+		req, body, errR = readHTTPRequest(br, c)
+		if errR != nil {
+			if !errors.Is(errR, io.EOF) {
+				l.Error("failed to read http request", errR)
+			}
+			return
+		}
+	}
+}
+
+var ipv6ConsumerMask = net.CIDRMask(64, 128)
+
+// quotaConsumer limits ipv6 clients by /64, single host usually gets the whole network
+func (s *Service) quotaConsumer(clientKey, remoteAddr string) string {
+	if s.conf.Quota.By == limiter.ByKey && clientKey != "" {
+		return clientKey
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return (&net.IPNet{IP: ip.Mask(ipv6ConsumerMask), Mask: ipv6ConsumerMask}).String()
+	}
+	return host
+}
+
+// writeHTTPError answers client on behalf of the proxy and asks to close the connection
+func writeHTTPError(c io.Writer, status int, headers map[string]string) error {
+	return writeHTTPResponse(c, status, headers, []byte(http.StatusText(status)))
+}
+
+// writeHTTPResponse is plain text unless headers set another content type, connection is closed after it
+func writeHTTPResponse(c io.Writer, status int, headers map[string]string, body []byte) error {
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(headers)+1),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp.Write(c)
+}
+
+type upstreamConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// readHTTPRequest reads request with whole body, so it can be inspected and forwarded again
+func readHTTPRequest(br *bufio.Reader, c io.Writer) (*http.Request, []byte, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		// client waits for confirmation before sending body, answer here since body is buffered anyway
+		if _, err = io.WriteString(c, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+			return nil, nil, fmt.Errorf("failed to write continue response: %w", err)
+		}
+		req.Header.Del("Expect")
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return req, body, nil
+}
+
+// roundTrip writes request to the server and reads response head, body is left for streaming.
+// informational responses are passed to the client as is
+func roundTrip(c io.Writer, server io.Writer, serverBr *bufio.Reader, req *http.Request, body []byte) (*http.Response, error) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Transfer-Encoding") // avoid mismatch after ContentLength reset
+	if err := req.Write(server); err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	for {
+		resp, err := http.ReadResponse(serverBr, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		if resp.StatusCode < 100 || resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		if err = resp.Write(c); err != nil {
+			return nil, fmt.Errorf("failed to write informational response: %w", err)
+		}
+	}
+}
+
+// isTunnelResponse reports whether connection stops being HTTP after the response (websocket, h2c upgrade, CONNECT)
+func isTunnelResponse(req *http.Request, resp *http.Response) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	return req.Method == http.MethodConnect && resp.StatusCode/100 == 2
+}
@@ -0,0 +1,76 @@
+package proxier
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const ModeHTTPConnect = "http_connect"
+
+// serveHTTPConnect handles CONNECT host:port of forward proxy clients, destination must pass egress allowlist
+func (s *Service) serveHTTPConnect(l logger.AppLogger, c net.Conn, metered *meteredConn) {
+	br := bufio.NewReaderSize(c, sniffBufferSize)
+	_ = c.SetReadDeadline(time.Now().Add(ForwardHandshakeTimeout))
+	req, err := http.ReadRequest(br)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		l.Error("failed to read connect request", err)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		l.Info("rejected non connect request", logger.WithString("method", req.Method))
+		_ = writeHTTPError(c, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	clientKey := ""
+	if s.auth != nil {
+		user, password, ok := proxyBasicAuth(req)
+		if !ok || !s.auth.checkPassword(user, password) {
+			s.handleAuthFailure(l, c.RemoteAddr().String())
+			_ = writeHTTPError(c, http.StatusProxyAuthRequired, map[string]string{"Proxy-Authenticate": s.auth.challenge()})
+			return
+		}
+		clientKey = user
+		l = l.With(logger.WithString("client_key", clientKey))
+	}
+	host, rawPort, err := net.SplitHostPort(req.Host)
+	port, errP := strconv.Atoi(rawPort)
+	if err != nil || errP != nil {
+		l.Info("rejected connect request with invalid target", logger.WithString("target", req.Host))
+		_ = writeHTTPError(c, http.StatusBadRequest, nil)
+		return
+	}
+	target := net.JoinHostPort(host, rawPort)
+	l = l.With(logger.WithString("target", target))
+
+	server, destination, err := s.dialEgress(l, c, host, port)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errEgressDenied) {
+			status = http.StatusForbidden
+		}
+		_ = writeHTTPError(c, status, nil)
+		return
+	}
+	defer server.Close()
+	if _, err = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		l.Error("failed to write connect response", err)
+		return
+	}
+	l.Info("http connect tunnel established", logger.WithString("destination", destination.addr))
+	pipe(c, br, server, server) // client may send first bytes of tunnel right after request
+	s.trackForwardSession(entities.ProtocolHTTPConnect, c, target, clientKey, destination, metered)
+}
+
+// proxyBasicAuth returns credentials of Proxy-Authorization header
+func proxyBasicAuth(req *http.Request) (user, password string, ok bool) {
+	r := &http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+	return r.BasicAuth()
+}
@@ -0,0 +1,101 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceHTTPConnect(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tunneled")
+	}))
+	t.Cleanup(upstream.Close)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentials, []byte("ci-runner:secret\n"), 0o600))
+	target := fmt.Sprintf("127.0.0.1:%d", upstreamPort(t, upstream))
+
+	var sessionBytes atomic.Int64
+	container.SrvNotificatorMock.EXPECT().SendInfoNewSession(gomock.Any(), target, gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, entities.ProtocolHTTPConnect, n.Protocol)
+			require.Equal(t, target, n.RemoteURL)
+			require.Equal(t, "ci-runner", n.ClientKey)
+			sessionBytes.Add(n.Bytes)
+			return nil
+		}).MinTimes(1)
+	container.SrvNotificatorMock.EXPECT().SendInfoAuthFailed("127.0.0.1", gomock.Any(), gomock.Any()).AnyTimes()
+	cfg := &proxier.Config{
+		ListenPort: test_utils.GetFreePort(t),
+		Mode:       proxier.ModeHTTPConnect,
+		NotifyHTTP: true,
+		Egress:     &proxier.EgressAllowlist{CIDRs: []string{"127.0.0.0/8"}},
+		Auth:       &proxier.AuthConfig{Type: proxier.AuthTypeBasic, CredentialsFile: credentials},
+	}
+	srvProxy := startProxy(t, container, cfg)
+	client := func(password string) *http.Client {
+		transport := upstream.Client().Transport.(*http.Transport).Clone()
+		transport.DisableKeepAlives = true
+		transport.Proxy = http.ProxyURL(&url.URL{
+			Scheme: "http",
+			User:   url.UserPassword("ci-runner", password),
+			Host:   fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort),
+		})
+		return &http.Client{Timeout: 2 * time.Second, Transport: transport}
+	}
+
+	t.Run("allowed destination", func(t *testing.T) {
+		// when
+		resp, err := client("secret").Get("https://" + target + "/")
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// then
+		require.Equal(t, "tunneled", string(data), "tls to destination goes through the tunnel")
+	})
+	t.Run("denied destination", func(t *testing.T) {
+		// when
+		_, err := client("secret").Get("https://10.255.255.1/")
+
+		// then
+		require.ErrorContains(t, err, http.StatusText(http.StatusForbidden))
+	})
+	t.Run("wrong password", func(t *testing.T) {
+		// when
+		_, err := client("guess").Get("https://" + target + "/")
+
+		// then
+		require.ErrorContains(t, err, http.StatusText(http.StatusProxyAuthRequired))
+	})
+	t.Run("not connect request", func(t *testing.T) {
+		// when
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// then
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	require.Eventually(t, func() bool {
+		return sessionBytes.Load() > 0
+	}, 2*time.Second, 50*time.Millisecond, "tunnel bytes are reported")
+	srvProxy.Stop()
+}
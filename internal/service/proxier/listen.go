@@ -0,0 +1,130 @@
+package proxier
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenAddresses accepts single address or list of them in config:
+// IPv4 or IPv6 address, `*` for dual-stack, interface name like eth0 or host, optionally with own port,
+// or unix:///path for unix socket
+type ListenAddresses []string
+
+func (a *ListenAddresses) UnmarshalYAML(unmarshal func(any) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*a = ListenAddresses{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("error decode listen_address: %w", err)
+	}
+	*a = list
+	return nil
+}
+
+type listenEndpoint struct {
+	network string // tcp4, tcp6 or tcp (dual-stack), same for udp, or unix
+	address string
+}
+
+// listenEndpoints resolves configured addresses, without them proxy listens on all IPv4 interfaces
+func (s *Service) listenEndpoints(protocol string) ([]listenEndpoint, error) {
+	defaultPort := strconv.Itoa(s.conf.ListenPort)
+	if len(s.conf.ListenAddress) == 0 {
+		return []listenEndpoint{{network: protocol + "4", address: ":" + defaultPort}}, nil
+	}
+	endpoints := make([]listenEndpoint, 0, len(s.conf.ListenAddress))
+	for _, entry := range s.conf.ListenAddress {
+		if network, path := splitUnixAddress(entry); network == "unix" {
+			if protocol != ProtocolTCP {
+				return nil, fmt.Errorf("unix socket %s is supported for tcp only", path)
+			}
+			endpoints = append(endpoints, listenEndpoint{network: network, address: path})
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil { // no port, for example `::1` or `eth0`
+			host, port = strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]"), defaultPort
+		}
+		switch {
+		case host == "*":
+			endpoints = append(endpoints, listenEndpoint{network: protocol, address: ":" + port})
+		case net.ParseIP(host) != nil:
+			endpoints = append(endpoints, listenEndpoint{network: ipNetwork(protocol, net.ParseIP(host)), address: net.JoinHostPort(host, port)})
+		default:
+			ifaceEndpoints, errI := interfaceEndpoints(protocol, host, port)
+			if errI != nil {
+				return nil, errI
+			}
+			if ifaceEndpoints == nil { // not an interface, let resolver handle hostname like localhost
+				ifaceEndpoints = []listenEndpoint{{network: protocol, address: net.JoinHostPort(host, port)}}
+			}
+			endpoints = append(endpoints, ifaceEndpoints...)
+		}
+	}
+	return endpoints, nil
+}
+
+// interfaceEndpoints returns endpoint for every address of the interface, nil if there is no such interface
+func interfaceEndpoints(protocol, name, port string) ([]listenEndpoint, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil //nolint:nilerr // name is a hostname then
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("error list addresses of %s: %w", name, err)
+	}
+	endpoints := make([]listenEndpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		host := ipNet.IP.String()
+		if ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() == nil {
+			host += "%" + iface.Name // link-local address is ambiguous without zone
+		}
+		endpoints = append(endpoints, listenEndpoint{network: ipNetwork(protocol, ipNet.IP), address: net.JoinHostPort(host, port)})
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("interface %s has no addresses", name)
+	}
+	return endpoints, nil
+}
+
+func ipNetwork(protocol string, ip net.IP) string {
+	if ip.To4() != nil {
+		return protocol + "4"
+	}
+	return protocol + "6"
+}
+
+// trackListener remembers listener to close it on Stop, listener opened after Stop is closed at once
+func (s *Service) trackListener(l io.Closer) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.stopped {
+		_ = l.Close()
+		return false
+	}
+	s.listeners = append(s.listeners, l)
+	return true
+}
+
+func (s *Service) closeListeners() {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.stopped = true
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			s.log.Error("failed to close listener", err)
+		}
+	}
+	s.listeners = nil
+}
@@ -0,0 +1,94 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestListenAddressConfig(t *testing.T) {
+	table := []struct {
+		name     string
+		raw      string
+		expected proxier.ListenAddresses
+	}{
+		{name: "single address", raw: "listen_address: 127.0.0.1", expected: proxier.ListenAddresses{"127.0.0.1"}},
+		{name: "list of addresses", raw: "listen_address: [\"::1\", eth0, \"10.0.0.1:9000\"]", expected: proxier.ListenAddresses{"::1", "eth0", "10.0.0.1:9000"}},
+		{name: "not set", raw: "listen_port: 80"},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			var cfg proxier.Config
+			err := yaml.Unmarshal([]byte(tc.raw), &cfg)
+
+			// then
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, cfg.ListenAddress)
+		})
+	}
+}
+
+func TestServiceListenAddresses(t *testing.T) {
+	// given
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 loopback is not available")
+	}
+	require.NoError(t, probe.Close())
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	extraPort := test_utils.GetFreePort(t)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		ListenAddress:      proxier.ListenAddresses{"127.0.0.1", "::1", fmt.Sprintf("lo:%d", extraPort)},
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, container.Log, container.SrvNotificatorMock)
+	stopped := make(chan struct{})
+	go func() {
+		srvProxy.Start()
+		close(stopped)
+	}()
+
+	// when
+	urls := []string{
+		fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort),
+		fmt.Sprintf("http://[::1]:%d/", cfg.ListenPort),
+		fmt.Sprintf("http://127.0.0.1:%d/", extraPort), // every address of interface
+		fmt.Sprintf("http://[::1]:%d/", extraPort),
+	}
+
+	// then
+	for _, url := range urls {
+		require.Eventually(t, func() bool {
+			resp, errG := (&http.Client{Timeout: time.Second}).Get(url)
+			if errG != nil {
+				return false
+			}
+			_ = resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}, 2*time.Second, 20*time.Millisecond, url)
+	}
+	srvProxy.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("listeners were not closed by Stop")
+	}
+	_, err = net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+	require.Error(t, err)
+}
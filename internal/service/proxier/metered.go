@@ -0,0 +1,25 @@
+package proxier
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// meteredConn counts bytes passed through the client connection
+type meteredConn struct {
+	net.Conn
+	received atomic.Int64
+	sent     atomic.Int64
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Add(int64(n))
+	return n, err
+}
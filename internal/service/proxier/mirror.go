@@ -0,0 +1,260 @@
+package proxier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/utils"
+	"time"
+)
+
+const (
+	defaultMirrorQueueSize = 1_000
+	defaultMirrorWorkers   = 4
+	defaultMirrorTimeout   = 10 * time.Second
+	mirrorCompareLimit     = 1 << 20 // bigger responses are not compared
+	mirrorSnippetLen       = 256
+)
+
+// MirrorConfig copies HTTP requests to secondary destination, responses of mirror are discarded.
+// mirror is fed from bounded queue, requests are dropped when it is full, so primary path is never slowed down
+type MirrorConfig struct {
+	DestinationAddress string             `yaml:"destination_address"`
+	DestinationPort    int                `yaml:"destination_port"`
+	UpstreamTLS        *UpstreamTLSConfig `yaml:"upstream_tls"`
+	QueueSize          int                `yaml:"queue_size"`      // 1000 by default
+	Workers            int                `yaml:"workers"`         // concurrent requests to mirror, 4 by default
+	Timeout            time.Duration      `yaml:"timeout"`         // 10s by default
+	CompareJSONRPC     bool               `yaml:"compare_jsonrpc"` // report JSON-RPC calls answered differently by mirror
+}
+
+type mirrorRequest struct {
+	req        *http.Request // snapshot before header rules, body is in separate field
+	body       []byte
+	remoteAddr string
+	rpcMethod  string // set if primary response is compared
+	status     int
+	primary    *limitedBuffer
+}
+
+type mirror struct {
+	conf     *MirrorConfig
+	upstream *upstream
+	client   *http.Client
+	queue    chan *mirrorRequest
+	workers  int
+
+	mirrored   atomic.Int64
+	dropped    atomic.Int64
+	failed     atomic.Int64
+	compared   atomic.Int64
+	mismatches *eventTracker // keyed by rpc method and statuses, guarded by Service.mu
+}
+
+func (s *Service) newMirror(conf *MirrorConfig) (*mirror, error) {
+	u, err := newUpstream(conf.DestinationAddress, conf.DestinationPort, conf.UpstreamTLS, "")
+	if err != nil {
+		return nil, fmt.Errorf("error init mirror upstream: %w", err)
+	}
+	queueSize, workers, timeout := conf.QueueSize, conf.Workers, conf.Timeout
+	if queueSize <= 0 {
+		queueSize = defaultMirrorQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultMirrorWorkers
+	}
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+	m := &mirror{
+		conf:       conf,
+		upstream:   u,
+		queue:      make(chan *mirrorRequest, queueSize),
+		workers:    workers,
+		mismatches: newEventTracker(s.conf.MaxTrackedEvents),
+	}
+	m.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return s.dialDestination(u, nil) // no proxy protocol for mirror, client is not needed
+			},
+			MaxIdleConnsPerHost: workers,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return m, nil
+}
+
+// mirrorSnapshot copies request before it is sent to primary, nil if mirror is not configured
+func (s *Service) mirrorSnapshot(req *http.Request, body []byte, remoteAddr string) *mirrorRequest {
+	if s.mirror == nil {
+		return nil
+	}
+	return &mirrorRequest{req: req.Clone(s.ctx), body: body, remoteAddr: remoteAddr}
+}
+
+// capturePrimary tees JSON-RPC response of primary while it is streamed to client.
+// encoded responses are not compared, compression output may differ for the same content
+func (s *Service) capturePrimary(mr *mirrorRequest, resp *http.Response) {
+	if mr == nil || !s.mirror.conf.CompareJSONRPC || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	if mr.rpcMethod = jsonRPCMethod(mr.body); mr.rpcMethod == "" {
+		return
+	}
+	mr.status, mr.primary = resp.StatusCode, &limitedBuffer{limit: mirrorCompareLimit}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, mr.primary), resp.Body}
+}
+
+// enqueueMirror never blocks, request is dropped if mirror does not keep up
+func (s *Service) enqueueMirror(mr *mirrorRequest) {
+	if mr == nil {
+		return
+	}
+	if mr.primary != nil && mr.primary.truncated {
+		mr.rpcMethod = ""
+	}
+	select {
+	case s.mirror.queue <- mr:
+	default:
+		s.mirror.dropped.Add(1)
+	}
+}
+
+func (s *Service) bgMirror() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case mr := <-s.mirror.queue:
+			s.sendMirror(mr)
+		}
+	}
+}
+
+func (s *Service) sendMirror(mr *mirrorRequest) {
+	m := s.mirror
+	req := mr.req
+	req.URL.Scheme, req.URL.Host = "http", m.upstream.addr
+	req.RequestURI = ""
+	req.Body = io.NopCloser(bytes.NewReader(mr.body))
+	req.ContentLength = int64(len(mr.body))
+	req.Header.Del("Transfer-Encoding")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.failed.Add(1)
+		s.log.Error("failed to mirror request", err, logger.WithString("mirror", m.upstream.addr))
+		return
+	}
+	defer resp.Body.Close()
+	m.mirrored.Add(1)
+	if mr.rpcMethod == "" {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mirrorCompareLimit))
+	if err != nil {
+		m.failed.Add(1)
+		s.log.Error("failed to read mirror response", err, logger.WithString("mirror", m.upstream.addr))
+		return
+	}
+	m.compared.Add(1)
+	primary := mr.primary.buf.Bytes()
+	if resp.StatusCode == mr.status && utils.SameJSON(primary, body) {
+		return
+	}
+	n := &entities.Notification{
+		Method:      mr.rpcMethod,
+		Body:        snippet(mr.body),
+		Destination: m.upstream.addr,
+		Status:      fmt.Sprintf("%d/%d", mr.status, resp.StatusCode), // primary/mirror
+	}
+	s.mu.Lock()
+	_, seen := m.mismatches.entries[n.NotifyID()]
+	full := len(m.mismatches.entries) >= m.mismatches.limit
+	m.mismatches.track(n)
+	s.mu.Unlock()
+	if seen || full { // details are logged once per method between dumps, flood of methods is only counted
+		return
+	}
+	s.log.Info("got mirror response mismatch",
+		logger.WithString("mirror", m.upstream.addr),
+		logger.WithString("remote_ip", mr.remoteAddr),
+		logger.WithString("rpc_method", mr.rpcMethod),
+		logger.WithString("payload", n.Body),
+		logger.WithInt("primary_status", mr.status),
+		logger.WithString("primary_response", snippet(primary)),
+		logger.WithInt("mirror_status", resp.StatusCode),
+		logger.WithString("mirror_response", snippet(body)),
+	)
+}
+
+// dumpMirror logs counters of mirrored traffic, called under Service.mu
+func (s *Service) dumpMirror() {
+	if s.mirror == nil {
+		return
+	}
+	m := s.mirror
+	mirrored, dropped, failed, compared := m.mirrored.Swap(0), m.dropped.Swap(0), m.failed.Swap(0), m.compared.Swap(0)
+	if mirrored+dropped+failed > 0 {
+		s.log.Info("got mirrored requests",
+			logger.WithString("mirror", m.upstream.addr),
+			logger.WithInt64("mirrored", mirrored),
+			logger.WithInt64("dropped", dropped),
+			logger.WithInt64("failed", failed),
+			logger.WithInt64("compared", compared),
+		)
+	}
+	mismatches, evicted, evictedHits := m.mismatches.take()
+	for _, e := range mismatches {
+		if err := s.notificator.SendInfoMirrorMismatch(e.event, m.upstream.addr, e.count); err != nil {
+			s.log.Error("failed send notification", err)
+		}
+		s.log.Info("got mirror response mismatches",
+			logger.WithString("mirror", m.upstream.addr),
+			logger.WithString("rpc_method", e.event.Method),
+			logger.WithInt("count", e.count),
+		)
+	}
+	if evicted > 0 {
+		s.log.Info("got untracked mirror response mismatches",
+			logger.WithString("mirror", m.upstream.addr),
+			logger.WithInt("evicted_methods", evicted),
+			logger.WithInt("count", evictedHits),
+			logger.WithInt("max_tracked_events", m.mismatches.limit),
+		)
+	}
+}
+
+func snippet(b []byte) string {
+	if len(b) > mirrorSnippetLen {
+		return string(b[:mirrorSnippetLen]) + "..."
+	}
+	return string(b)
+}
+
+// limitedBuffer keeps first bytes of stream and notes whether anything was cut off
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
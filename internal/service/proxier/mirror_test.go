@@ -0,0 +1,203 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceMirrorCompareJSONRPC(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "eth_chainId") {
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`)
+	}))
+	t.Cleanup(primary.Close)
+	var mirrored atomic.Int64
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored.Add(1)
+		require.Empty(t, r.Header.Get("X-Api-Key"), "header rules of primary destination are not copied to mirror")
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "eth_chainId") {
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x5"}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":1, "result":"0x10", "jsonrpc":"2.0"}`)
+	}))
+	t.Cleanup(shadow.Close)
+	shadowAddr := fmt.Sprintf("127.0.0.1:%d", upstreamPort(t, shadow))
+	container.SrvNotificatorMock.EXPECT().SendInfoMirrorMismatch(gomock.Any(), shadowAddr, 1).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, "eth_chainId", n.Method)
+			require.Equal(t, "200/200", n.Status)
+			require.Contains(t, n.Body, "eth_chainId")
+			return nil
+		}).Times(1)
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, primary),
+		HeaderRules:        proxier.HeaderRules{Set: map[string]string{"X-Api-Key": "secret"}},
+		Mirror: &proxier.MirrorConfig{
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    upstreamPort(t, shadow),
+			CompareJSONRPC:     true,
+		},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+
+	// when
+	var results []string
+	for _, method := range []string{"eth_blockNumber", "eth_chainId"} {
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			var err error
+			resp, err = http.Post(proxyURL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[]}`))
+			return err == nil
+		}, 2*time.Second, 20*time.Millisecond)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		results = append(results, string(data))
+	}
+
+	// then
+	require.Equal(t, []string{`{"jsonrpc":"2.0","id":1,"result":"0x10"}`, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`}, results, "clients get primary responses")
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"got mirror response mismatches"`)
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Equal(t, int64(2), mirrored.Load())
+	require.Contains(t, logs.String(), `"_rpc_method":"eth_chainId","_count":"1"`)
+	require.NotContains(t, logs.String(), `"_rpc_method":"eth_blockNumber"`, "formatting and key order are ignored")
+}
+
+func TestServiceMirrorMismatchesBounded(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`)
+	}))
+	t.Cleanup(primary.Close)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method does not exist"}}`)
+	}))
+	t.Cleanup(shadow.Close)
+	var notified atomic.Int64
+	container.SrvNotificatorMock.EXPECT().SendInfoMirrorMismatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ *entities.Notification, _ string, count int) error {
+			notified.Add(int64(count))
+			return nil
+		}).AnyTimes()
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, primary),
+		MaxTrackedEvents:   2,
+		Mirror: &proxier.MirrorConfig{
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    upstreamPort(t, shadow),
+			CompareJSONRPC:     true,
+		},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+
+	// when
+	const calls = 20
+	for i := range calls {
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			var err error
+			resp, err = http.Post(proxyURL, "application/json", strings.NewReader(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"random_%d"}`, i)))
+			return err == nil
+		}, 2*time.Second, 20*time.Millisecond)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	// then
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"got untracked mirror response mismatches"`)
+	}, 2*time.Second, 50*time.Millisecond, "random methods are counted in overflow bucket")
+	require.Contains(t, logs.String(), `"_max_tracked_events":"2"`)
+	require.Less(t, notified.Load(), int64(calls), "only tracked methods are notified")
+}
+
+func TestServiceMirrorSlowShadow(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(primary.Close)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(shadow.Close)
+	t.Cleanup(func() { close(release) })
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, primary),
+		Mirror: &proxier.MirrorConfig{
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    upstreamPort(t, shadow),
+			QueueSize:          1,
+			Workers:            1,
+		},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort)
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// when
+	startedAt := time.Now()
+	for range 10 {
+		code, body := plainGet(t, proxyURL)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "ok", body)
+	}
+
+	// then
+	require.Less(t, time.Since(startedAt), time.Second, "stuck mirror does not slow down primary")
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"got mirrored requests"`)
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Regexp(t, `"_dropped":"[1-9]`, logs.String())
+}
@@ -0,0 +1,149 @@
+package proxier
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"time"
+)
+
+const (
+	ClientAuthRequire = "require" // handshake fails without valid client certificate
+	ClientAuthRequest = "request" // client certificate is optional, but verified if presented
+)
+
+var errRevokedCertificate = errors.New("client certificate is revoked")
+
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	case ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode: %s", mode)
+	}
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error read ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// revokedSerials keeps serial numbers from deny file, one hex serial per line, `#` starts a comment
+type revokedSerials struct {
+	file    string
+	serials atomic.Pointer[map[string]struct{}]
+	modTime time.Time // last loaded file state, accessed by single goroutine
+}
+
+func newRevokedSerials(file string) (*revokedSerials, error) {
+	r := &revokedSerials{file: file}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads deny file if it changed since the previous load
+func (r *revokedSerials) reload() (bool, error) {
+	modTime, err := latestModTime(r.file)
+	if err != nil {
+		return false, err
+	}
+	if modTime.Equal(r.modTime) {
+		return false, nil
+	}
+	f, err := os.Open(r.file)
+	if err != nil {
+		return false, fmt.Errorf("error open revoked serials file: %w", err)
+	}
+	defer f.Close()
+
+	serials := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = normalizeSerial(line); line != "" {
+			serials[line] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return false, fmt.Errorf("error read revoked serials file: %w", err)
+	}
+	r.serials.Store(&serials)
+	r.modTime = modTime
+	return true, nil
+}
+
+func (r *revokedSerials) revoked(cert *x509.Certificate) bool {
+	_, ok := (*r.serials.Load())[cert.SerialNumber.Text(16)]
+	return ok
+}
+
+// verifyConnection rejects handshake if client presented revoked certificate
+func (r *revokedSerials) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) > 0 && r.revoked(state.PeerCertificates[0]) {
+		return fmt.Errorf("%w: serial %s", errRevokedCertificate, state.PeerCertificates[0].SerialNumber.Text(16))
+	}
+	return nil
+}
+
+// normalizeSerial accepts openssl style `0A:1B:...` and plain hex
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(serial), ":", ""))
+	return strings.TrimLeft(strings.TrimPrefix(serial, "0x"), "0")
+}
+
+// connWrapper is implemented by wrappers of client connection, so terminated tls connection can be found under them
+type connWrapper interface {
+	NetConn() net.Conn
+}
+
+// peerIdentity returns client certificate of terminated tls connection, nil if client presented none
+func peerIdentity(c net.Conn) *entities.ClientCert {
+	tlsConn, ok := c.(*tls.Conn)
+	for !ok {
+		wrapper, isWrapper := c.(connWrapper)
+		if !isWrapper {
+			return nil
+		}
+		c = wrapper.NetConn()
+		tlsConn, ok = c.(*tls.Conn)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	leaf := certs[0]
+	sans := append([]string{}, leaf.DNSNames...)
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	fingerprint := sha256.Sum256(leaf.Raw)
+	return &entities.ClientCert{
+		Subject:     leaf.Subject.String(),
+		SANs:        sans,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+}
@@ -0,0 +1,101 @@
+package proxier_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceMutualTLS(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "plain")
+	}))
+	t.Cleanup(upstream.Close)
+	ca := test_utils.NewTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := test_utils.WriteCertificate(t, dir, ca.Issue(t, "proxy.local", 1))
+	revokedFile := filepath.Join(dir, "revoked")
+	require.NoError(t, os.WriteFile(revokedFile, []byte("# compromised laptop\n00:C8\n"), 0o600))
+	newConfig := func(clientAuth string) *proxier.Config {
+		return &proxier.Config{
+			ListenPort:         test_utils.GetFreePort(t),
+			DestinationAddress: "127.0.0.1",
+			DestinationPort:    upstreamPort(t, upstream),
+			NotifyHTTP:         true,
+			TLS: &proxier.TLSConfig{
+				CertFile:           certFile,
+				KeyFile:            keyFile,
+				ClientCAFile:       ca.WriteCA(t, dir),
+				ClientAuth:         clientAuth,
+				RevokedSerialsFile: revokedFile,
+			},
+		}
+	}
+	var identified atomic.Bool
+	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			if n.ClientCert != nil {
+				identified.Store(true)
+				require.Equal(t, "CN=alice,O=tcp_proxy", n.ClientCert.Subject)
+				require.Equal(t, []string{"alice", "localhost"}, n.ClientCert.SANs)
+				require.Len(t, n.ClientCert.Fingerprint, 64)
+			}
+			return nil
+		}).AnyTimes()
+	required, requested := newConfig(proxier.ClientAuthRequire), newConfig(proxier.ClientAuthRequest)
+	required.Faults = []proxier.FaultRule{{Kind: proxier.FaultLatency, Latency: time.Millisecond}} // wraps client connection
+	srvProxy := startProxy(t, container, required)
+	startProxy(t, container, requested)
+
+	table := []struct {
+		name    string
+		port    int
+		certs   []tls.Certificate
+		success bool
+	}{
+		{name: "valid client certificate", port: required.ListenPort, certs: []tls.Certificate{ca.Issue(t, "alice", 100)}, success: true},
+		{name: "missing client certificate", port: required.ListenPort},
+		{name: "revoked client certificate", port: required.ListenPort, certs: []tls.Certificate{ca.Issue(t, "alice", 200)}},
+		{name: "untrusted client certificate", port: required.ListenPort, certs: []tls.Certificate{test_utils.NewTestCA(t).Issue(t, "alice", 100)}},
+		{name: "optional client certificate", port: requested.ListenPort, success: true},
+		{name: "optional revoked client certificate", port: requested.ListenPort, certs: []tls.Certificate{ca.Issue(t, "alice", 200)}},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			client := &http.Client{Transport: &http.Transport{
+				DisableKeepAlives: true,
+				TLSClientConfig:   &tls.Config{ServerName: "localhost", RootCAs: ca.Pool(), Certificates: tc.certs, MinVersion: tls.VersionTLS12},
+			}}
+			resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/eth/blocks", tc.port))
+
+			// then
+			if !tc.success {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, "plain", string(data))
+		})
+	}
+	srvProxy.Stop()
+	require.True(t, identified.Load(), "notification must carry client certificate identity")
+}
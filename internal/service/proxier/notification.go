@@ -1,6 +1,7 @@
 package proxier
 
 import (
+	"net"
 	"net/http"
 	"strings"
 	"tcp_proxy/internal/entities"
@@ -12,32 +13,61 @@ var (
 	DumpNotificationsInterval = time.Minute * 30
 )
 
-func (s *Service) handleHTTPNotification(r *http.Request, body []byte, remoteIP string) {
+const quotaTopConsumers = 10
+
+func (s *Service) handleHTTPNotification(r *http.Request, body []byte, remoteIP, clientKey string, clientCert *entities.ClientCert, destination string) {
 	if !strings.Contains(r.URL.String(), "/eth/") {
 		return // disable non eth requests
 	}
 	bodyStr := string(body)
 	if len(body) > 1024 {
-		b := append(body[:1024], []byte("…<truncated>")...)
+		b := append(body[:1024:1024], []byte("…<truncated>")...) // limit capacity to keep forwarded body intact
 		bodyStr = strings.ReplaceAll(string(b), "```", "`\u200b``")
 	}
 	d := &entities.Notification{
+		Protocol:    entities.ProtocolHTTP,
 		RemoteIP:    remoteIP,
 		RemoteURL:   r.URL.String(),
 		Method:      r.Method,
 		ContentType: r.Header.Get("Content-Type"),
 		BodyLength:  int64(len(body)),
 		Body:        bodyStr,
+		ClientKey:   clientKey,
+		ClientCert:  clientCert,
+		Destination: destination,
 	}
-	notifyID := d.NotifyID()
+	s.trackEvent(d)
+}
+
+func (s *Service) handleGRPCNotification(remoteIP string, clientCert *entities.ClientCert, st *h2Stream, grpcStatus string) {
+	s.trackEvent(&entities.Notification{
+		Protocol:    entities.ProtocolGRPC,
+		RemoteIP:    remoteIP,
+		RemoteURL:   st.path,
+		Method:      "POST",
+		ContentType: st.contentType,
+		BodyLength:  st.bodyLength,
+		Destination: s.destinationAddr,
+		Status:      grpcStatus,
+		ClientCert:  clientCert,
+	})
+}
+
+func (s *Service) trackEvent(d *entities.Notification) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.events.track(d)
+}
 
-	s.eventsTracker[notifyID] = d
-	if _, ok := s.eventsCounter[notifyID]; !ok {
-		s.eventsCounter[notifyID] = 0
+func (s *Service) handleAuthFailure(l logger.AppLogger, remoteAddr string) {
+	l.Info("client authentication failed")
+	remoteIP := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteIP = host
 	}
-	s.eventsCounter[notifyID]++
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authFailures.track(&entities.Notification{RemoteIP: remoteIP, Destination: s.destinationAddr})
 }
 
 func (s *Service) bgDumpNotifications() {
@@ -56,18 +86,133 @@ func (s *Service) bgDumpNotifications() {
 func (s *Service) dumpNotifications() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for id, event := range s.eventsTracker {
-		if err := s.notificator.SendInfoNewRequest(event, s.destinationAddr, s.eventsCounter[id]); err != nil {
+	tracked, evicted, evictedHits := s.events.take()
+	for _, e := range tracked {
+		event, count := e.event, e.count
+		var err error
+		switch event.Protocol {
+		case entities.ProtocolGRPC:
+			err = s.notificator.SendInfoNewGRPCRequest(event, event.Destination, count)
+		case entities.ProtocolTLS:
+			err = s.notificator.SendInfoNewTLSRequest(event, event.Destination, count)
+		case entities.ProtocolUDP, entities.ProtocolSOCKS5, entities.ProtocolHTTPConnect:
+			err = s.notificator.SendInfoNewSession(event, event.Destination, count)
+		case entities.ProtocolDetected:
+			err = s.notificator.SendInfoProtocolDetected(event, event.Destination, count)
+		default:
+			err = s.notificator.SendInfoNewRequest(event, event.Destination, count)
+		}
+		if err != nil {
 			s.log.Error("failed send notification", err)
 		}
-		s.log.Info("got http request",
+		fields := []logger.StringWith{
 			logger.WithString("key", event.Method),
 			logger.WithString("payload", event.Body),
 			logger.WithString("path", event.RemoteURL),
-			logger.WithInt("count", s.eventsCounter[id]),
+			logger.WithInt("count", count),
 			logger.WithString("remote_ip", event.RemoteIP),
+			logger.WithString("client_key", event.ClientKey),
+			logger.WithString("destination", event.Destination),
+			logger.WithString("status", event.Status),
+		}
+		if e.error > 0 { // key was tracked after eviction, some events may be counted in overflow bucket
+			fields = append(fields, logger.WithInt("count_error", e.error))
+		}
+		if event.Packets > 0 || event.Bytes > 0 {
+			fields = append(fields,
+				logger.WithInt64("packets", event.Packets),
+				logger.WithInt64("bytes", event.Bytes),
+			)
+		}
+		if event.ClientCert != nil {
+			fields = append(fields,
+				logger.WithString("client_subject", event.ClientCert.Subject),
+				logger.WithString("client_sans", strings.Join(event.ClientCert.SANs, ",")),
+				logger.WithString("client_fingerprint", event.ClientCert.Fingerprint),
+			)
+		}
+		if event.TLS != nil {
+			fields = append(fields,
+				logger.WithString("alpn", strings.Join(event.TLS.ALPN, ",")),
+				logger.WithString("tls_versions", strings.Join(event.TLS.Versions, ",")),
+				logger.WithString("ja3", event.TLS.JA3),
+				logger.WithString("ja4", event.TLS.JA4),
+			)
+		}
+		s.log.Info("got "+event.Protocol+" request", fields...)
+	}
+	if evicted > 0 {
+		s.log.Info("got untracked events",
+			logger.WithInt("evicted_keys", evicted),
+			logger.WithInt("count", evictedHits),
+			logger.WithInt("max_tracked_events", s.events.limit),
+		)
+	}
+	failures, evicted, evictedHits := s.authFailures.take()
+	for _, e := range failures {
+		if err := s.notificator.SendInfoAuthFailed(e.event.RemoteIP, s.destinationAddr, e.count); err != nil {
+			s.log.Error("failed send notification", err)
+		}
+		s.log.Info("got failed authentication attempts",
+			logger.WithString("remote_ip", e.event.RemoteIP),
+			logger.WithInt("count", e.count),
 		)
-		delete(s.eventsTracker, id)
-		delete(s.eventsCounter, id)
+	}
+	if evicted > 0 {
+		s.log.Info("got untracked failed authentication attempts",
+			logger.WithInt("evicted_ips", evicted),
+			logger.WithInt("count", evictedHits),
+			logger.WithInt("max_tracked_events", s.authFailures.limit),
+		)
+	}
+	for failure, counts := range s.upstreamFailures {
+		if err := s.notificator.SendInfoUpstreamFailed(failure.destination, failure.stage, counts); err != nil {
+			s.log.Error("failed send notification", err)
+		}
+		s.log.Info("got upstream connection failures",
+			logger.WithString("destination", failure.destination),
+			logger.WithString("stage", failure.stage),
+			logger.WithInt("count", counts),
+		)
+		delete(s.upstreamFailures, failure)
+	}
+	for mismatch, counts := range s.protocolMismatches {
+		s.log.Info("got protocol mismatches",
+			logger.WithString("expected_protocol", mismatch.expected),
+			logger.WithString("detected_protocol", mismatch.detected),
+			logger.WithInt("count", counts),
+		)
+		delete(s.protocolMismatches, mismatch)
+	}
+	if dropped := s.udpDropped.Swap(0); dropped > 0 {
+		s.log.Info("got dropped udp datagrams of new sources",
+			logger.WithInt64("count", dropped),
+			logger.WithInt("max_udp_sessions", s.maxUDPSessions()),
+		)
+	}
+	s.dumpQuota()
+	s.dumpMirror()
+}
+
+func (s *Service) dumpQuota() {
+	if s.quota == nil {
+		return
+	}
+	exceeded, top := s.quota.TakeReport(quotaTopConsumers)
+	if len(exceeded) > 0 {
+		if err := s.notificator.SendQuotaReport(s.destinationAddr, exceeded, top); err != nil {
+			s.log.Error("failed send notification", err)
+		}
+	}
+	for i := range exceeded {
+		s.log.Info("client exceeded quota",
+			logger.WithString("consumer", exceeded[i].Consumer),
+			logger.WithInt64("rejected", exceeded[i].Rejected),
+			logger.WithInt64("daily", exceeded[i].Daily),
+			logger.WithInt64("monthly", exceeded[i].Monthly),
+		)
+	}
+	if err := s.quota.Save(); err != nil {
+		s.log.Error("failed to save quota state", err)
 	}
 }
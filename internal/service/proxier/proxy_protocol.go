@@ -0,0 +1,195 @@
+package proxier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+
+	proxyHeaderTimeout = 5 * time.Second
+	proxyV1MaxLength   = 107 // including CRLF, per spec
+)
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errNoProxyHeader = errors.New("connection does not start with proxy protocol header")
+)
+
+// proxyProtocolConn reports client address received in PROXY header instead of load balancer address
+type proxyProtocolConn struct {
+	net.Conn
+	r          *bufio.Reader // may hold bytes received after the header
+	remoteAddr net.Addr
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	if len(cidrs) == 0 {
+		return nil, errors.New("accept_proxy_protocol requires trusted_proxies, any peer could spoof client address otherwise")
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("error parse trusted proxy cidr: %w", err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether peer may send PROXY header, peers without ip address are not trusted
+func trustedProxy(nets []*net.IPNet, peer net.Addr) bool {
+	var ip net.IP
+	if host, _, err := net.SplitHostPort(peer.String()); err == nil {
+		ip = net.ParseIP(host)
+	}
+	return ip != nil && slices.ContainsFunc(nets, func(ipNet *net.IPNet) bool { return ipNet.Contains(ip) })
+}
+
+// readProxyHeader consumes PROXY protocol v1 or v2 header, connections without header are rejected
+func readProxyHeader(c net.Conn) (net.Conn, error) {
+	_ = c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+
+	r := bufio.NewReaderSize(c, 256)
+	pc := &proxyProtocolConn{Conn: c, r: r, remoteAddr: c.RemoteAddr()}
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error read proxy header: %w", err)
+	}
+	var src net.Addr
+	switch {
+	case bytes.Equal(prefix, proxyV2Signature):
+		src, err = readProxyHeaderV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		src, err = readProxyHeaderV1(r)
+	default:
+		return nil, errNoProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src != nil { // LOCAL command and unknown families keep the real peer
+		pc.remoteAddr = src
+	}
+	return pc, nil
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, fmt.Errorf("proxy v1 header is too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error read proxy v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+	parts := strings.Fields(string(line))
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed proxy v1 header: %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(parts[2])
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("malformed proxy v1 source: %s:%s", parts[2], parts[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error read proxy v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy v2 version: %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("error read proxy v2 addresses: %w", err)
+	}
+	if header[12]&0x0f == 0 { // LOCAL, for example health check of load balancer
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short proxy v2 ipv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short proxy v2 ipv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// writeProxyHeader announces client address to upstream before any forwarded byte
+func writeProxyHeader(w io.Writer, version string, src, dst net.Addr) error {
+	srcTCP, okSrc := src.(*net.TCPAddr)
+	dstTCP, okDst := dst.(*net.TCPAddr)
+	known := okSrc && okDst
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			header = []byte("PROXY UNKNOWN\r\n")
+			break
+		}
+		family, srcIP, dstIP := "TCP6", srcTCP.IP.To16(), dstTCP.IP.To16()
+		if srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil {
+			family, srcIP, dstIP = "TCP4", srcTCP.IP.To4(), dstTCP.IP.To4()
+		}
+		header = fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcTCP.Port, dstTCP.Port)
+	case ProxyProtocolV2:
+		header = append(header, proxyV2Signature...)
+		if !known {
+			header = append(header, 0x20, 0x00, 0x00, 0x00) // LOCAL, upstream keeps our address
+			break
+		}
+		header = append(header, 0x21) // version 2, PROXY
+		if srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil {
+			header = append(header, 0x11, 0x00, 12) // TCP over IPv4
+			header = append(header, srcTCP.IP.To4()...)
+			header = append(header, dstTCP.IP.To4()...)
+		} else {
+			header = append(header, 0x21, 0x00, 36) // TCP over IPv6
+			header = append(header, srcTCP.IP.To16()...)
+			header = append(header, dstTCP.IP.To16()...)
+		}
+		header = binary.BigEndian.AppendUint16(header, uint16(srcTCP.Port))
+		header = binary.BigEndian.AppendUint16(header, uint16(dstTCP.Port))
+	default:
+		return fmt.Errorf("unknown proxy protocol version: %s", version)
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("error write proxy header: %w", err)
+	}
+	return nil
+}
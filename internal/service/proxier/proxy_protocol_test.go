@@ -0,0 +1,145 @@
+package proxier_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceProxyProtocol(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("X-Real-IP"))
+	}))
+	t.Cleanup(upstream.Close)
+	back := &proxier.Config{ // behind front proxy, trusts its v2 header
+		ListenPort:          test_utils.GetFreePort(t),
+		DestinationAddress:  "127.0.0.1",
+		DestinationPort:     upstreamPort(t, upstream),
+		AcceptProxyProtocol: true,
+		TrustedProxies:      []string{"127.0.0.1/32"},
+		HeaderRules:         proxier.HeaderRules{ForwardedFor: true},
+	}
+	front := &proxier.Config{ // behind load balancer speaking v1
+		ListenPort:          test_utils.GetFreePort(t),
+		DestinationAddress:  "127.0.0.1",
+		DestinationPort:     back.ListenPort,
+		AcceptProxyProtocol: true,
+		TrustedProxies:      []string{"127.0.0.1/32"},
+		SendProxyProtocol:   proxier.ProxyProtocolV2,
+	}
+	startProxy(t, container, back)
+	startProxy(t, container, front)
+
+	table := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "ipv4 client", header: "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n", expected: "203.0.113.7"},
+		{name: "ipv6 client", header: "PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\n", expected: "2001:db8::7"},
+		{name: "unknown client", header: "PROXY UNKNOWN\r\n", expected: "127.0.0.1"},
+		{name: "missing header", expected: ""},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", front.ListenPort), time.Second)
+			require.NoError(t, err)
+			defer c.Close()
+			_, err = io.WriteString(c, tc.header+"GET / HTTP/1.1\r\nHost: rpc.local\r\nConnection: close\r\n\r\n")
+			require.NoError(t, err)
+			resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+
+			// then
+			if tc.expected == "" {
+				require.Error(t, err, "connection without header must be closed")
+				return
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tc.expected, string(data))
+		})
+	}
+}
+
+func TestServiceProxyProtocolUntrustedPeer(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+	cfg := &proxier.Config{
+		ListenPort:          test_utils.GetFreePort(t),
+		DestinationAddress:  "127.0.0.1",
+		DestinationPort:     upstreamPort(t, upstream),
+		AcceptProxyProtocol: true,
+		TrustedProxies:      []string{"10.0.0.0/8"},
+	}
+	startProxy(t, container, cfg)
+
+	// when
+	c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), time.Second)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = io.WriteString(c, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nGET / HTTP/1.1\r\nHost: rpc.local\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	_, err = http.ReadResponse(bufio.NewReader(c), nil)
+
+	// then
+	require.Error(t, err, "client must not choose its own address")
+}
+
+func TestServiceSendProxyProtocolV1(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	headers := make(chan string, 1)
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			if line != "" {
+				headers <- line
+			}
+			_ = c.Close()
+		}
+	}()
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    ln.Addr().(*net.TCPAddr).Port,
+		SendProxyProtocol:  proxier.ProxyProtocolV1,
+	}
+	startProxy(t, container, cfg)
+	<-headers // probe connection of startProxy
+
+	// when
+	c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), time.Second)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = io.WriteString(c, "PING\n")
+	require.NoError(t, err)
+
+	// then
+	clientPort := c.LocalAddr().(*net.TCPAddr).Port
+	require.Equal(t, fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", clientPort, cfg.ListenPort), <-headers)
+}
@@ -0,0 +1,90 @@
+package proxier_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/limiter"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceQuota(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	container.SrvNotificatorMock.EXPECT().SendQuotaReport(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ string, exceeded, top []entities.QuotaUsage) error {
+			require.Len(t, exceeded, 1)
+			require.Equal(t, "127.0.0.1", exceeded[0].Consumer)
+			require.Equal(t, int64(2), top[0].Daily)
+			return nil
+		}).Times(1)
+
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		Quota:              &limiter.Config{By: limiter.ByIP, Daily: 2},
+	}
+	startProxy(t, container, cfg)
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d/eth/blocks", cfg.ListenPort)
+
+	// when
+	require.Equal(t, http.StatusOK, doRequest(t, proxyURL, nil))
+	require.Equal(t, http.StatusOK, doRequest(t, proxyURL, nil))
+
+	// then
+	req, err := http.NewRequest(http.MethodGet, proxyURL, http.NoBody)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+func TestServiceQuotaIPv6Network(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	consumers := make(chan string, 1)
+	container.SrvNotificatorMock.EXPECT().SendQuotaReport(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ string, exceeded, _ []entities.QuotaUsage) error {
+			consumers <- exceeded[0].Consumer
+			return nil
+		}).Times(1)
+	cfg := &proxier.Config{
+		ListenAddress:      proxier.ListenAddresses{"127.0.0.1", "::1"},
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		Quota:              &limiter.Config{By: limiter.ByIP, Daily: 1},
+	}
+	startProxy(t, container, cfg)
+	proxyURL := fmt.Sprintf("http://[::1]:%d/eth/blocks", cfg.ListenPort)
+
+	// when
+	statuses := []int{doRequest(t, proxyURL, nil), doRequest(t, proxyURL, nil)}
+
+	// then
+	require.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, statuses)
+	select {
+	case consumer := <-consumers:
+		require.Equal(t, "::/64", consumer, "ipv6 clients are limited by /64 network")
+	case <-time.After(2 * time.Second):
+		t.Fatal("quota report was not sent")
+	}
+}
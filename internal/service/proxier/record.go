@@ -0,0 +1,182 @@
+package proxier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	defaultRecordMaxBody   = 1 << 20
+	defaultRecordQueueSize = 1_000
+)
+
+// RecordConfig writes HTTP request/response pairs as JSON lines, file is input of replay subcommand.
+// requests are recorded as sent by client without credentials, header rules are not applied yet
+type RecordConfig struct {
+	File         string `yaml:"file"`           // appended if exists
+	MaxBodyBytes int    `yaml:"max_body_bytes"` // longer bodies are truncated, 1MB by default
+}
+
+type recorder struct {
+	maxBody int
+	queue   chan *entities.RecordedExchange
+	dropped atomic.Int64
+
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	closed bool
+}
+
+type recording struct {
+	exchange  *entities.RecordedExchange
+	response  *limitedBuffer
+	startedAt time.Time
+}
+
+func newRecorder(conf *RecordConfig) (*recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(conf.File), 0o750); err != nil {
+		return nil, fmt.Errorf("error create record dir: %w", err)
+	}
+	file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("error open record file: %w", err)
+	}
+	maxBody := conf.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = defaultRecordMaxBody
+	}
+	return &recorder{
+		maxBody: maxBody,
+		queue:   make(chan *entities.RecordedExchange, defaultRecordQueueSize),
+		file:    file,
+		w:       bufio.NewWriter(file),
+	}, nil
+}
+
+// recordSnapshot copies request before header rules change it, nil if recording is not configured
+func (s *Service) recordSnapshot(req *http.Request, body []byte, remoteAddr, destination string) *recording {
+	if s.recorder == nil {
+		return nil
+	}
+	exchange := &entities.RecordedExchange{
+		RemoteIP:    remoteAddr,
+		Destination: destination,
+		Request: entities.RecordedMessage{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Host:   req.Host,
+			Header: req.Header.Clone(),
+		},
+	}
+	exchange.Request.SetBody(body[:min(len(body), s.recorder.maxBody)])
+	exchange.Request.Truncated = len(body) > s.recorder.maxBody
+	return &recording{exchange: exchange, startedAt: time.Now()}
+}
+
+// captureRecorded tees response body while it is streamed to client
+func (s *Service) captureRecorded(rec *recording, resp *http.Response) {
+	if rec == nil {
+		return
+	}
+	rec.exchange.Response.Status = resp.StatusCode
+	rec.exchange.Response.Header = resp.Header.Clone()
+	rec.response = &limitedBuffer{limit: s.recorder.maxBody}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, rec.response), resp.Body}
+}
+
+// finishRecord never blocks, exchange is dropped if file writer does not keep up
+func (s *Service) finishRecord(rec *recording) {
+	if rec == nil || rec.response == nil {
+		return
+	}
+	rec.exchange.StartedAt = rec.startedAt
+	rec.exchange.Latency = time.Since(rec.startedAt)
+	rec.exchange.Response.SetBody(rec.response.buf.Bytes())
+	rec.exchange.Response.Truncated = rec.response.truncated
+	select {
+	case s.recorder.queue <- rec.exchange:
+	default:
+		s.recorder.dropped.Add(1)
+	}
+}
+
+func (s *Service) bgRecord() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.recorder.flush(s.log)
+			return
+		case exchange := <-s.recorder.queue:
+			s.recorder.write(s.log, exchange, len(s.recorder.queue) == 0)
+		}
+	}
+}
+
+func (r *recorder) write(l logger.AppLogger, exchange *entities.RecordedExchange, flush bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		l.Error("failed to encode recorded exchange", err)
+		return
+	}
+	if _, err = r.w.Write(append(line, '\n')); err == nil && flush {
+		err = r.w.Flush()
+	}
+	if err != nil {
+		l.Error("failed to write recorded exchange", err)
+	}
+}
+
+func (r *recorder) flush(l logger.AppLogger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		l.Error("failed to flush record file", err)
+	}
+}
+
+// close writes queued exchanges and closes file, later exchanges are ignored
+func (r *recorder) close(l logger.AppLogger) {
+	for drained := false; !drained; {
+		select {
+		case exchange := <-r.queue:
+			r.write(l, exchange, false)
+		default:
+			drained = true
+		}
+	}
+	r.flush(l)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if err := r.file.Close(); err != nil {
+		l.Error("failed to close record file", err)
+	}
+	if dropped := r.dropped.Swap(0); dropped > 0 {
+		l.Info("dropped recorded exchanges", logger.WithInt64("count", dropped))
+	}
+}
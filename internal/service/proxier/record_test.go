@@ -0,0 +1,62 @@
+package proxier_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceRecord(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "provider-secret", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`)
+	}))
+	t.Cleanup(upstream.Close)
+	file := filepath.Join(t.TempDir(), "records", "eth.jsonl")
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		HeaderRules:        proxier.HeaderRules{Set: map[string]string{"X-Api-Key": "provider-secret"}},
+		Record:             &proxier.RecordConfig{File: file},
+	}
+	srvProxy := startProxy(t, container, cfg)
+	payload := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+
+	// when
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/eth/?chain=1", cfg.ListenPort), "application/json", strings.NewReader(payload))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	srvProxy.Stop()
+
+	// then
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var exchange entities.RecordedExchange
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &exchange))
+	require.Equal(t, http.MethodPost, exchange.Request.Method)
+	require.Equal(t, "/eth/?chain=1", exchange.Request.URL)
+	require.Equal(t, payload, exchange.Request.Body)
+	require.Empty(t, exchange.Request.Header.Get("X-Api-Key"), "provider secret set by header rules is not recorded")
+	require.Equal(t, http.StatusOK, exchange.Response.Status)
+	require.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`, exchange.Response.Body)
+	require.Equal(t, "application/json", exchange.Response.Header.Get("Content-Type"))
+	require.Positive(t, exchange.Latency)
+	require.False(t, exchange.StartedAt.IsZero())
+}
@@ -0,0 +1,103 @@
+package proxier
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Route sends matched HTTP requests or TLS connections to own destination, all non-empty conditions must match.
+// routes with server_name match TLS connections by SNI without decryption, others match HTTP requests
+type Route struct {
+	Host       string `yaml:"host"`        // exact host or wildcard like *.example.com
+	PathPrefix string `yaml:"path_prefix"` // for example /eth/
+	RPCMethod  string `yaml:"rpc_method"`  // json-rpc method pattern, for example eth_*
+	ServerName string `yaml:"server_name"` // exact SNI or wildcard like *.example.com
+
+	DestinationAddress string             `yaml:"destination_address"`
+	DestinationPort    int                `yaml:"destination_port"`
+	UpstreamTLS        *UpstreamTLSConfig `yaml:"upstream_tls"`
+	SendProxyProtocol  string             `yaml:"send_proxy_protocol"` // v1 or v2
+}
+
+func (r *Route) match(req *http.Request, rpcMethod string) bool {
+	if r.ServerName != "" {
+		return false
+	}
+	if r.Host != "" {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !matchHostname(r.Host, host) {
+			return false
+		}
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	if r.RPCMethod != "" {
+		if ok, _ := path.Match(r.RPCMethod, rpcMethod); !ok || rpcMethod == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// routeDestination returns destination of the first matched route, default destination otherwise
+func (s *Service) routeDestination(req *http.Request, body []byte) *upstream {
+	if len(s.conf.Routes) == 0 {
+		return s.upstream
+	}
+	rpcMethod := ""
+	for i := range s.conf.Routes {
+		if s.conf.Routes[i].RPCMethod != "" && rpcMethod == "" {
+			rpcMethod = jsonRPCMethod(body) // parse body only when it matters
+		}
+		if s.conf.Routes[i].match(req, rpcMethod) {
+			return s.routeUpstreams[i]
+		}
+	}
+	return s.upstream
+}
+
+// sniDestination returns destination of the first route matched by SNI, default destination otherwise
+func (s *Service) sniDestination(serverName string) *upstream {
+	for i := range s.conf.Routes {
+		if s.conf.Routes[i].ServerName != "" && serverName != "" && matchHostname(s.conf.Routes[i].ServerName, serverName) {
+			return s.routeUpstreams[i]
+		}
+	}
+	return s.upstream
+}
+
+// matchHostname compares hostnames case-insensitive, pattern `*.example.com` matches any subdomain
+func matchHostname(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.TrimSuffix(strings.ToLower(host), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
+
+// jsonRPCMethod extracts method of json-rpc call, batch is routed by the first call
+func jsonRPCMethod(body []byte) string {
+	var call struct {
+		Method string `json:"method"`
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			return ""
+		}
+		body = batch[0]
+	}
+	if err := json.Unmarshal(body, &call); err != nil {
+		return ""
+	}
+	return call.Method
+}
@@ -0,0 +1,73 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceRoutes(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	newUpstream := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	defaultSrv, bscSrv, rpcSrv := newUpstream("default"), newUpstream("bsc"), newUpstream("rpc")
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, defaultSrv),
+		Routes: []proxier.Route{
+			{PathPrefix: "/bsc/", DestinationAddress: "127.0.0.1", DestinationPort: upstreamPort(t, bscSrv)},
+			{Host: "*.bsc.local", DestinationAddress: "127.0.0.1", DestinationPort: upstreamPort(t, bscSrv)},
+			{PathPrefix: "/eth/", RPCMethod: "eth_get*", DestinationAddress: "127.0.0.1", DestinationPort: upstreamPort(t, rpcSrv)},
+		},
+	}
+	startProxy(t, container, cfg)
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	table := []struct {
+		name     string
+		host     string
+		path     string
+		body     string
+		expected string
+	}{
+		{name: "path prefix", path: "/bsc/blocks", expected: "bsc"},
+		{name: "default route", path: "/eth/blocks", expected: "default"},
+		{name: "wildcard host", host: "node1.bsc.local", path: "/", expected: "bsc"},
+		{name: "json-rpc method", path: "/eth/", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance"}`, expected: "rpc"},
+		{name: "json-rpc batch", path: "/eth/", body: `[{"jsonrpc":"2.0","id":1,"method":"eth_getCode"}]`, expected: "rpc"},
+		{name: "json-rpc method mismatch", path: "/eth/", body: `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`, expected: "default"},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			req, err := http.NewRequestWithContext(container.Ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d%s", cfg.ListenPort, tc.path), strings.NewReader(tc.body))
+			require.NoError(t, err)
+			if tc.host != "" {
+				req.Host = tc.host
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			// then
+			require.Equal(t, tc.expected, string(data))
+		})
+	}
+}
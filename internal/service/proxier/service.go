@@ -4,12 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/limiter"
 	"tcp_proxy/internal/logger"
 	"tcp_proxy/internal/notifier"
 	"time"
@@ -20,6 +24,40 @@ type Config struct {
 	DestinationPort    int    `yaml:"destination_port"`
 	DestinationAddress string `yaml:"destination_address"`
 	NotifyHTTP         bool   `yaml:"notify_http"`
+	MaxTrackedEvents   int    `yaml:"max_tracked_events"` // distinct notifications, failed auth ips and mirror mismatches kept between dumps, 10000 by default
+
+	ListenAddress ListenAddresses  `yaml:"listen_address"` // all IPv4 interfaces by default
+	UnixSocket    UnixSocketConfig `yaml:"unix_socket"`    // permissions of unix:// listen sockets
+
+	AcceptProxyProtocol bool     `yaml:"accept_proxy_protocol"` // real client address comes from PROXY v1/v2 header of load balancer
+	TrustedProxies      []string `yaml:"trusted_proxies"`       // CIDRs of load balancers allowed to send PROXY header, required with accept_proxy_protocol
+	SendProxyProtocol   string   `yaml:"send_proxy_protocol"`   // v1 or v2, header for default destination
+
+	Protocol          string        `yaml:"protocol"`            // tcp (default) or udp
+	UDPSessionTimeout time.Duration `yaml:"udp_session_timeout"` // idle time after which udp client mapping is dropped
+	MaxUDPSessions    int           `yaml:"max_udp_sessions"`    // datagrams of new sources are dropped above it, 10000 by default
+
+	Detect         *DetectConfig `yaml:"detect"`          // recognize protocol by first bytes before forwarding
+	ExpectProtocol string        `yaml:"expect_protocol"` // http, grpc, tls or any (default), other traffic is closed before dial
+
+	Mode   string           `yaml:"mode"`   // reverse proxy to destination by default, socks5 or http_connect turn it into forward proxy
+	Egress *EgressAllowlist `yaml:"egress"` // destinations allowed in forward proxy modes
+
+	Routes      []Route         `yaml:"routes"`
+	HeaderRules HeaderRules     `yaml:"header_rules"`
+	Auth        *AuthConfig     `yaml:"auth"`
+	Quota       *limiter.Config `yaml:"quota"`
+
+	GRPCAccess *GRPCAccessConfig `yaml:"grpc_access"`
+	TLS        *TLSConfig        `yaml:"tls"`
+
+	UpstreamTLS *UpstreamTLSConfig `yaml:"upstream_tls"`
+	Mirror      *MirrorConfig      `yaml:"mirror"` // shadow destination for HTTP requests
+	Record      *RecordConfig      `yaml:"record"` // HTTP exchanges for replay subcommand
+
+	Capture    *CaptureConfig `yaml:"capture"`     // pcapng capture started with service or by trigger file
+	Faults     []FaultRule    `yaml:"faults"`      // chaos testing of clients, see SetFaultRules
+	FaultsFile string         `yaml:"faults_file"` // yaml list of fault rules replacing `faults`, reloaded on change
 }
 
 type Service struct {
@@ -28,16 +66,38 @@ type Service struct {
 	conf *Config
 
 	destinationAddr string
+	upstream        *upstream
+	routeUpstreams  []*upstream // same order as conf.Routes
 	notificator     notifier.Notificator
+	auth            *authenticator
+	quota           *limiter.Limiter
+	tlsConf         *tls.Config
+	certs           *certReloader
+	revoked         *revokedSerials
+	egress          *egressPolicy
+	trustedProxies  []*net.IPNet
+	mirror          *mirror
+	recorder        *recorder
+	capture         atomic.Pointer[capture]
+	udpDropped      atomic.Int64 // datagrams of new sources above max_udp_sessions
+	faults          atomic.Pointer[faultSet]
+	faultsFile      *faultsFile
+
+	mu           sync.Mutex
+	events       *eventTracker
+	authFailures *eventTracker // keyed by remote ip, bounded like events
+
+	upstreamFailures   map[upstreamFailure]int
+	protocolMismatches map[protocolMismatch]int
 
-	mu            sync.Mutex
-	eventsTracker map[string]*entities.Notification
-	eventsCounter map[string]int
+	listenersMu sync.Mutex
+	listeners   []io.Closer
+	stopped     bool
 }
 
 func NewService(ctx context.Context, conf *Config, log logger.AppLogger, notificator notifier.Notificator) *Service {
-	destinationAddr := fmt.Sprintf("%s:%d", conf.DestinationAddress, conf.DestinationPort)
-	return &Service{
+	destinationAddr := destinationAddress(conf.DestinationAddress, conf.DestinationPort)
+	srv := &Service{
 		ctx:             ctx,
 		conf:            conf,
 		destinationAddr: destinationAddr,
@@ -47,85 +107,352 @@ func NewService(ctx context.Context, conf *Config, log logger.AppLogger, notific
 		),
 		notificator: notificator,
 
-		eventsTracker: make(map[string]*entities.Notification, 1_000),
-		eventsCounter: make(map[string]int, 1_000),
+		events:       newEventTracker(conf.MaxTrackedEvents),
+		authFailures: newEventTracker(conf.MaxTrackedEvents),
+
+		upstreamFailures:   make(map[upstreamFailure]int),
+		protocolMismatches: make(map[protocolMismatch]int),
+	}
+	switch conf.Protocol {
+	case "", ProtocolTCP, ProtocolUDP:
+	default:
+		srv.log.Fatal("failed to init service", fmt.Errorf("unknown protocol: %s", conf.Protocol))
+	}
+	if err := validateExpectProtocol(conf); err != nil {
+		srv.log.Fatal("failed to init service", err)
+	}
+	switch conf.Mode {
+	case "":
+	case ModeSOCKS5, ModeHTTPConnect:
+		if conf.Egress == nil {
+			srv.log.Fatal("failed to init service", fmt.Errorf("mode %s requires egress allowlist", conf.Mode))
+		}
+		if conf.Auth != nil && conf.Auth.Type != AuthTypeBasic {
+			srv.log.Fatal("failed to init service", fmt.Errorf("mode %s supports only basic auth", conf.Mode))
+		}
+	default:
+		srv.log.Fatal("failed to init service", fmt.Errorf("unknown mode: %s", conf.Mode))
+	}
+	var err error
+	if conf.AcceptProxyProtocol {
+		if srv.trustedProxies, err = parseTrustedProxies(conf.TrustedProxies); err != nil {
+			srv.log.Fatal("failed to init proxy protocol", err)
+		}
+	}
+	if conf.Egress != nil {
+		if srv.egress, err = newEgressPolicy(conf.Egress); err != nil {
+			srv.log.Fatal("failed to init egress allowlist", err)
+		}
+	}
+	if srv.upstream, err = newUpstream(conf.DestinationAddress, conf.DestinationPort, conf.UpstreamTLS, conf.SendProxyProtocol); err != nil {
+		srv.log.Fatal("failed to init upstream", err)
+	}
+	srv.routeUpstreams = make([]*upstream, len(conf.Routes))
+	for i := range conf.Routes {
+		route := &conf.Routes[i]
+		if srv.routeUpstreams[i], err = newUpstream(route.DestinationAddress, route.DestinationPort, route.UpstreamTLS, route.SendProxyProtocol); err != nil {
+			srv.log.Fatal("failed to init route upstream", err)
+		}
+	}
+	if conf.Mirror != nil {
+		if srv.mirror, err = srv.newMirror(conf.Mirror); err != nil {
+			srv.log.Fatal("failed to init mirror", err)
+		}
+	}
+	if conf.Record != nil {
+		if srv.recorder, err = newRecorder(conf.Record); err != nil {
+			srv.log.Fatal("failed to init recording", err)
+		}
+	}
+	if len(conf.Faults) > 0 && conf.FaultsFile != "" {
+		srv.log.Fatal("failed to init fault rules", errors.New("faults and faults_file are mutually exclusive"))
+	}
+	if len(conf.Faults) > 0 {
+		if err = srv.SetFaultRules(conf.Faults); err != nil {
+			srv.log.Fatal("failed to init fault rules", err)
+		}
+	}
+	if conf.FaultsFile != "" {
+		srv.faultsFile = &faultsFile{file: conf.FaultsFile}
+		rules, err := srv.faultsFile.load()
+		if err == nil {
+			err = srv.SetFaultRules(rules)
+		}
+		if err != nil {
+			srv.log.Fatal("failed to init fault rules", err)
+		}
+	}
+	if conf.Auth != nil {
+		auth, err := newAuthenticator(conf.Auth)
+		if err != nil {
+			srv.log.Fatal("failed to init authentication", err)
+		}
+		srv.auth = auth
+	}
+	if conf.Quota != nil {
+		if srv.quota, err = limiter.NewLimiter(*conf.Quota); err != nil {
+			srv.log.Fatal("failed to init quota", err)
+		}
+		if err = srv.quota.Load(); err != nil {
+			srv.log.Fatal("failed to load quota state", err)
+		}
+	}
+	if conf.TLS != nil {
+		certs, err := newCertReloader(conf.TLS.CertFile, conf.TLS.KeyFile)
+		if err != nil {
+			srv.log.Fatal("failed to load tls certificate", err)
+		}
+		if conf.TLS.RevokedSerialsFile != "" {
+			if srv.revoked, err = newRevokedSerials(conf.TLS.RevokedSerialsFile); err != nil {
+				srv.log.Fatal("failed to load revoked serials", err)
+			}
+		}
+		tlsConf, err := newServerTLSConfig(conf.TLS, certs, srv.revoked)
+		if err != nil {
+			srv.log.Fatal("failed to init tls", err)
+		}
+		srv.certs, srv.tlsConf = certs, tlsConf
 	}
+	return srv
 }
 
 func (s *Service) Start() {
 	go s.bgDumpNotifications()
+	if s.certs != nil {
+		go s.bgReloadTLSFiles()
+	}
+	if s.mirror != nil {
+		for range s.mirror.workers {
+			go s.bgMirror()
+		}
+	}
+	if s.recorder != nil {
+		go s.bgRecord()
+	}
+	if s.faultsFile != nil {
+		go s.bgReloadFaults()
+	}
 	s.log.Info("starting service")
-	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", s.conf.ListenPort))
+	if s.conf.Capture != nil && s.conf.Capture.TriggerFile != "" {
+		go s.bgCaptureTrigger()
+	} else if s.conf.Capture != nil {
+		if _, err := s.StartCapture(s.conf.Capture); err != nil {
+			s.log.Fatal("failed to start capture", err)
+		}
+	}
+	protocol := ProtocolTCP
+	if s.conf.Protocol == ProtocolUDP {
+		protocol = ProtocolUDP
+	}
+	endpoints, err := s.listenEndpoints(protocol)
 	if err != nil {
-		s.log.Fatal("failed to start listener", err)
+		s.log.Fatal("failed to resolve listen address", err)
+	}
+	var wg sync.WaitGroup
+	for _, endpoint := range endpoints {
+		l := s.log.With(logger.WithString("listen_address", endpoint.address))
+		if protocol == ProtocolUDP {
+			listener, errL := net.ListenPacket(endpoint.network, endpoint.address)
+			if errL != nil {
+				l.Fatal("failed to start listener", errL)
+			}
+			if s.trackListener(listener) {
+				wg.Go(func() { s.serveUDP(listener) })
+			}
+			continue
+		}
+		var (
+			listener net.Listener
+			errL     error
+		)
+		if endpoint.network == "unix" {
+			listener, errL = s.listenUnix(endpoint.address)
+		} else {
+			listener, errL = net.Listen(endpoint.network, endpoint.address)
+		}
+		if errL != nil {
+			l.Fatal("failed to start listener", errL)
+		}
+		if s.trackListener(listener) {
+			wg.Go(func() { s.acceptClients(l, listener) })
+		}
 	}
+	wg.Wait()
+}
+
+// acceptClients serves listener until it is closed by Stop
+func (s *Service) acceptClients(l logger.AppLogger, listener net.Listener) {
 	for {
 		client, errA := listener.Accept()
 		if errA != nil {
-			s.log.Error("failed to accept client", errA)
+			if errors.Is(errA, net.ErrClosed) {
+				return
+			}
+			l.Error("failed to accept client", errA)
 			continue
 		}
-		l := s.log.With(logger.WithString("remote_ip", client.RemoteAddr().String()))
-		l.Info("accepted new client")
-		go s.handle(l, client)
+		cl := s.log.With(logger.WithString("remote_ip", client.RemoteAddr().String()))
+		cl.Info("accepted new client")
+		go s.handle(cl, client)
 	}
 }
 
 func (s *Service) handle(l logger.AppLogger, c net.Conn) {
 	defer c.Close()
+	raw := c
 	if tcpConn, ok := c.(*net.TCPConn); ok {
 		_ = tcpConn.SetKeepAlive(true)
 		_ = tcpConn.SetKeepAlivePeriod(30 * time.Second)
 	}
-	br := bufio.NewReader(c)
+	startedAt, metered := time.Now(), &meteredConn{Conn: c}
+	c = metered
+	defer func() { // l is enriched while connection is handled
+		l.Info("client disconnected",
+			logger.WithInt64("bytes_received", metered.received.Load()),
+			logger.WithInt64("bytes_sent", metered.sent.Load()),
+			logger.WithString("duration", time.Since(startedAt).Round(time.Second).String()),
+		)
+	}()
+	if s.conf.AcceptProxyProtocol {
+		if !trustedProxy(s.trustedProxies, c.RemoteAddr()) {
+			l.Info("rejected proxy protocol connection from untrusted peer")
+			return
+		}
+		pc, err := readProxyHeader(c)
+		if err != nil {
+			l.Error("failed to read proxy protocol header", err)
+			return
+		}
+		l = s.log.With( // address of the load balancer is not interesting anymore
+			logger.WithString("remote_ip", pc.RemoteAddr().String()),
+			logger.WithString("proxy_ip", c.RemoteAddr().String()),
+		)
+		l.Info("got client address from proxy protocol header")
+		c = pc
+	}
+	if s.tlsConf != nil {
+		var (
+			tlsConn *tls.Conn
+			err     error
+		)
+		l, tlsConn, err = s.terminateTLS(l, c)
+		if err != nil {
+			l.Error("failed to terminate tls", err)
+			return
+		}
+		defer tlsConn.Close()
+		c = tlsConn
+	}
+	var stream *captureStream
+	if c, stream = s.captureConn(c); stream != nil {
+		defer stream.close()
+	}
+	c = s.injectConnFaults(l, c, raw)
+	switch s.conf.Mode {
+	case ModeSOCKS5:
+		s.serveSOCKS5(l, c, metered)
+		return
+	case ModeHTTPConnect:
+		s.serveHTTPConnect(l, c, metered)
+		return
+	}
+	br := bufio.NewReaderSize(c, sniffBufferSize)
+	if !s.checkExpectedProtocol(l, c, br) {
+		return
+	}
+	if s.conf.Detect != nil {
+		var ok bool
+		if l, ok = s.serveDetected(l, c, br); !ok {
+			return
+		}
+	}
 
-	var prefix io.Reader // bytes we must send first (the parsed HTTP request)
-	if s.conf.NotifyHTTP {
-		if looksLikeUnsecureGRPC(br) {
-			if err := s.notificator.SendInfoNewGRPCRequest(c.RemoteAddr().String(), s.destinationAddr); err != nil {
-				l.Info("got unsecure grpc request")
-				l.Error("failed to send unsecure grpc request", err)
+	destination := s.upstream
+	if s.httpAware() {
+		if s.auth != nil && !looksLikeHTTP(br) {
+			l.Info("rejected non http client, authentication required")
+			return
+		}
+		if looksLikeTLS(br) {
+			var serverName string
+			l, serverName = s.inspectClientHello(l, c, br)
+			destination = s.sniDestination(serverName)
+		} else if looksLikeUnsecureGRPC(br) {
+			if s.conf.NotifyHTTP || s.conf.GRPCAccess != nil {
+				s.serveH2C(l, c, br)
+				return
 			}
 		} else if looksLikeHTTP(br) {
 			_ = c.SetReadDeadline(time.Now().Add(300 * time.Millisecond)) // avoid hanging on non-HTTP
-			if req, err := http.ReadRequest(br); err == nil {
-				body, _ := io.ReadAll(req.Body)
-				_ = req.Body.Close()
-				s.handleHTTPNotification(req, body, c.RemoteAddr().String())
-
-				// rebuild raw request to forward
-				req.Body = io.NopCloser(bytes.NewReader(body))
-				req.ContentLength = int64(len(body))
-				req.Header.Del("Transfer-Encoding") // avoid mismatch after ContentLength reset
-
-				var buf bytes.Buffer
-				if err = req.Write(&buf); err == nil {
-					prefix = bytes.NewReader(buf.Bytes())
-				}
+			req, body, err := readHTTPRequest(br, c)
+			_ = c.SetReadDeadline(time.Time{})
+			if err != nil {
+				l.Error("failed to read http request", err)
+				return // consumed bytes can not be forwarded anymore
 			}
+			s.serveHTTP(l, c, br, req, body)
+			return
 		}
 	}
 
-	server, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", s.conf.DestinationAddress, s.conf.DestinationPort), 10*time.Second)
+	server, err := s.dialDestination(destination, c)
 	if err != nil {
-		l.Error("failed to connect to remote server", err)
+		s.handleDialFailure(l, destination, err)
 		return
 	}
 	defer server.Close()
+	pipe(c, br, server, server)
+}
 
-	src := io.Reader(br)
-	if prefix != nil {
-		src = io.MultiReader(prefix, br)
+// inspectClientHello records ClientHello of the encrypted connection, returns logger enriched with it and SNI
+func (s *Service) inspectClientHello(l logger.AppLogger, c net.Conn, br *bufio.Reader) (logger.AppLogger, string) {
+	_ = c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	hello, err := peekClientHello(br)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		l.Error("failed to parse tls client hello", err)
+		return l, ""
+	}
+	l = l.With(
+		logger.WithString("sni", hello.ServerName),
+		logger.WithString("alpn", strings.Join(hello.ALPN, ",")),
+		logger.WithString("tls_versions", strings.Join(hello.Versions, ",")),
+		logger.WithString("ja3", hello.JA3),
+		logger.WithString("ja4", hello.JA4),
+	)
+	l.Info("got tls client hello")
+	if s.conf.NotifyHTTP {
+		s.trackEvent(&entities.Notification{
+			Protocol:    entities.ProtocolTLS,
+			RemoteIP:    c.RemoteAddr().String(),
+			RemoteURL:   hello.ServerName,
+			Destination: s.sniDestination(hello.ServerName).addr,
+			TLS:         hello,
+		})
 	}
+	return l, hello.ServerName
+}
+
+func (s *Service) httpAware() bool {
+	return s.conf.NotifyHTTP || s.conf.HeaderRules.enabled() || len(s.conf.Routes) > 0 ||
+		s.conf.Auth != nil || s.conf.Quota != nil || s.conf.GRPCAccess != nil || s.conf.Mirror != nil || s.conf.Record != nil
+}
 
+// pipe copies data in both directions until one of the sides is done
+func pipe(client io.Writer, clientSrc io.Reader, server io.Writer, serverSrc io.Reader) {
 	errCh := make(chan error, 2)
-	go func() { _, e := io.Copy(server, src); errCh <- e }()
-	go func() { _, e := io.Copy(c, server); errCh <- e }()
+	go func() { _, e := io.Copy(server, clientSrc); errCh <- e }()
+	go func() { _, e := io.Copy(client, serverSrc); errCh <- e }()
 	<-errCh
 }
 
 func (s *Service) Stop() {
 	s.log.Info("stopping service")
+	s.closeListeners()
+	s.StopCapture()
+	if s.recorder != nil {
+		s.recorder.close(s.log)
+	}
 	s.dumpNotifications()
 }
 
@@ -136,7 +463,7 @@ func looksLikeUnsecureGRPC(br *bufio.Reader) bool {
 	if err != nil {
 		return false
 	}
-	return bytes.Equal(b, h2Preface)
+	return isH2Preface(b)
 }
 
 func looksLikeHTTP(br *bufio.Reader) bool {
@@ -144,7 +471,10 @@ func looksLikeHTTP(br *bufio.Reader) bool {
 	if err != nil {
 		return false
 	}
+	return hasHTTPMethodPrefix(b)
+}
 
+func hasHTTPMethodPrefix(b []byte) bool {
 	knownPrefixes := [][]byte{
 		[]byte("GET "),
 		[]byte("POST"),
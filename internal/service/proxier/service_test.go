@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"tcp_proxy/internal/entities"
 	"tcp_proxy/internal/service/proxier"
 	"tcp_proxy/internal/test_utils"
 	"tcp_proxy/internal/utils"
@@ -25,6 +26,8 @@ import (
 
 func init() {
 	proxier.DumpNotificationsInterval = 100 * time.Millisecond
+	proxier.ForwardHandshakeTimeout = time.Second
+	proxier.FaultsReloadInterval = 50 * time.Millisecond
 }
 
 func TestServiceHTTPRequest(t *testing.T) {
@@ -133,12 +136,23 @@ func TestServiceTCPRequest(t *testing.T) {
 func TestServiceSecureGRPCRequest(t *testing.T) {
 	container := test_utils.GetClean(t)
 	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewTLSRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Contains(t, n.TLS.ALPN, "h2")
+			return nil
+		}).Times(1)
 	testGRPCServer(t, container, true)
 }
 
 func TestServiceInSecureGRPCRequest(t *testing.T) {
 	container := test_utils.GetClean(t)
-	container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any()).Times(1)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any(), 1).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, test_utils.EchoMethod, n.RemoteURL)
+			require.Equal(t, "0", n.Status)
+			require.Equal(t, "application/grpc", n.ContentType)
+			return nil
+		}).Times(1)
 	testGRPCServer(t, container, false)
 }
 
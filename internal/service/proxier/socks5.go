@@ -0,0 +1,218 @@
+package proxier
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	ModeSOCKS5 = "socks5"
+
+	socks5Version         = 0x05
+	socks5AuthNone        = 0x00
+	socks5AuthPassword    = 0x02
+	socks5AuthUnavailable = 0xff
+	socks5CmdConnect      = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5Succeeded          = 0x00
+	socks5NotAllowed         = 0x02
+	socks5HostUnreachable    = 0x04
+	socks5CmdNotSupported    = 0x07
+	socks5AddrNotSupported   = 0x08
+	socks5PasswordAuthStatus = 0x01 // version of username/password subnegotiation, RFC 1929
+)
+
+// serveSOCKS5 handles CONNECT of SOCKS5 client, destination must pass egress allowlist
+func (s *Service) serveSOCKS5(l logger.AppLogger, c net.Conn, metered *meteredConn) {
+	_ = c.SetReadDeadline(time.Now().Add(ForwardHandshakeTimeout))
+	clientKey, err := s.socks5Handshake(l, c)
+	if err != nil {
+		l.Error("failed socks5 handshake", err)
+		return
+	}
+	if clientKey != "" {
+		l = l.With(logger.WithString("client_key", clientKey))
+	}
+	host, port, err := readSOCKS5Request(c)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		l.Error("failed to read socks5 request", err)
+		var replyErr *socks5ReplyError
+		if errors.As(err, &replyErr) {
+			_ = writeSOCKS5Reply(c, replyErr.code, nil)
+		}
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	l = l.With(logger.WithString("target", target))
+
+	server, destination, err := s.dialEgress(l, c, host, port)
+	if err != nil {
+		code := byte(socks5HostUnreachable)
+		if errors.Is(err, errEgressDenied) {
+			code = socks5NotAllowed
+		}
+		_ = writeSOCKS5Reply(c, code, nil)
+		return
+	}
+	defer server.Close()
+	if err = writeSOCKS5Reply(c, socks5Succeeded, server.LocalAddr()); err != nil {
+		l.Error("failed to write socks5 reply", err)
+		return
+	}
+	l.Info("socks5 tunnel established", logger.WithString("destination", destination.addr))
+	pipe(c, c, server, server)
+	s.trackForwardSession(entities.ProtocolSOCKS5, c, target, clientKey, destination, metered)
+}
+
+// socks5Handshake negotiates authentication method, returns name of the client credentials if auth is configured
+func (s *Service) socks5Handshake(l logger.AppLogger, c net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", fmt.Errorf("error read greeting: %w", err)
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported socks version: %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return "", fmt.Errorf("error read auth methods: %w", err)
+	}
+	required := byte(socks5AuthNone)
+	if s.auth != nil {
+		required = socks5AuthPassword
+	}
+	if !slices.Contains(methods, required) {
+		_, _ = c.Write([]byte{socks5Version, socks5AuthUnavailable})
+		return "", fmt.Errorf("client does not offer auth method %d", required)
+	}
+	if _, err := c.Write([]byte{socks5Version, required}); err != nil {
+		return "", fmt.Errorf("error write auth method: %w", err)
+	}
+	if s.auth == nil {
+		return "", nil
+	}
+
+	user, password, err := readSOCKS5Credentials(c)
+	if err != nil {
+		return "", err
+	}
+	if !s.auth.checkPassword(user, password) {
+		s.handleAuthFailure(l, c.RemoteAddr().String())
+		_, _ = c.Write([]byte{socks5PasswordAuthStatus, 0x01})
+		return "", fmt.Errorf("invalid credentials of %q", user)
+	}
+	if _, err = c.Write([]byte{socks5PasswordAuthStatus, 0x00}); err != nil {
+		return "", fmt.Errorf("error write auth status: %w", err)
+	}
+	return user, nil
+}
+
+func readSOCKS5Credentials(r io.Reader) (user, password string, err error) {
+	readField := func() (string, error) {
+		size := make([]byte, 1)
+		if _, errR := io.ReadFull(r, size); errR != nil {
+			return "", errR
+		}
+		field := make([]byte, size[0])
+		if _, errR := io.ReadFull(r, field); errR != nil {
+			return "", errR
+		}
+		return string(field), nil
+	}
+	version := make([]byte, 1)
+	if _, err = io.ReadFull(r, version); err != nil {
+		return "", "", fmt.Errorf("error read credentials: %w", err)
+	}
+	if version[0] != socks5PasswordAuthStatus {
+		return "", "", fmt.Errorf("unsupported auth version: %d", version[0])
+	}
+	if user, err = readField(); err != nil {
+		return "", "", fmt.Errorf("error read username: %w", err)
+	}
+	if password, err = readField(); err != nil {
+		return "", "", fmt.Errorf("error read password: %w", err)
+	}
+	return user, password, nil
+}
+
+type socks5ReplyError struct {
+	code byte
+	err  error
+}
+
+func (e *socks5ReplyError) Error() string { return e.err.Error() }
+
+func readSOCKS5Request(r io.Reader) (string, int, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, fmt.Errorf("error read request: %w", err)
+	}
+	if header[0] != socks5Version {
+		return "", 0, fmt.Errorf("unsupported socks version: %d", header[0])
+	}
+	if header[1] != socks5CmdConnect {
+		return "", 0, &socks5ReplyError{code: socks5CmdNotSupported, err: fmt.Errorf("unsupported command: %d", header[1])}
+	}
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		size := net.IPv4len
+		if header[3] == socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, fmt.Errorf("error read address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", 0, fmt.Errorf("error read domain: %w", err)
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", 0, fmt.Errorf("error read domain: %w", err)
+		}
+		host = string(domain)
+	default:
+		return "", 0, &socks5ReplyError{code: socks5AddrNotSupported, err: fmt.Errorf("unsupported address type: %d", header[3])}
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, fmt.Errorf("error read port: %w", err)
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+func writeSOCKS5Reply(w io.Writer, code byte, bound net.Addr) error {
+	reply := []byte{socks5Version, code, 0x00}
+	tcpAddr, ok := bound.(*net.TCPAddr)
+	switch {
+	case !ok:
+		reply = append(reply, socks5AddrIPv4, 0, 0, 0, 0, 0, 0)
+	case tcpAddr.IP.To4() != nil:
+		reply = append(reply, socks5AddrIPv4)
+		reply = append(reply, tcpAddr.IP.To4()...)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(tcpAddr.Port))
+	default:
+		reply = append(reply, socks5AddrIPv6)
+		reply = append(reply, tcpAddr.IP.To16()...)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(tcpAddr.Port))
+	}
+	_, err := w.Write(reply)
+	return err
+}
@@ -0,0 +1,116 @@
+package proxier_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/net/proxy"
+)
+
+func TestServiceSOCKS5(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tunneled")
+	}))
+	t.Cleanup(upstream.Close)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentials, []byte("alice:wonderland\n"), 0o600))
+	target := fmt.Sprintf("127.0.0.1:%d", upstreamPort(t, upstream))
+
+	var sessionBytes atomic.Int64
+	container.SrvNotificatorMock.EXPECT().SendInfoNewSession(gomock.Any(), target, gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, entities.ProtocolSOCKS5, n.Protocol)
+			require.Equal(t, target, n.RemoteURL)
+			require.Equal(t, "alice", n.ClientKey)
+			sessionBytes.Add(n.Bytes)
+			return nil
+		}).MinTimes(1)
+	container.SrvNotificatorMock.EXPECT().SendInfoAuthFailed("127.0.0.1", gomock.Any(), gomock.Any()).AnyTimes()
+	cfg := &proxier.Config{
+		ListenPort: test_utils.GetFreePort(t),
+		Mode:       proxier.ModeSOCKS5,
+		NotifyHTTP: true,
+		Egress:     &proxier.EgressAllowlist{CIDRs: []string{"127.0.0.0/8"}},
+		Auth:       &proxier.AuthConfig{Type: proxier.AuthTypeBasic, CredentialsFile: credentials},
+	}
+	srvProxy := startProxy(t, container, cfg)
+	proxyAddr := fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort)
+	client := func(password string) *http.Client {
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, &proxy.Auth{User: "alice", Password: password}, proxy.Direct)
+		require.NoError(t, err)
+		return &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+			},
+		}}
+	}
+
+	t.Run("allowed destination", func(t *testing.T) {
+		// when
+		resp, err := client("wonderland").Get("http://" + target + "/")
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// then
+		require.Equal(t, "tunneled", string(data))
+	})
+	t.Run("denied destination", func(t *testing.T) {
+		// when
+		_, err := client("wonderland").Get("http://10.255.255.1/")
+
+		// then
+		require.ErrorContains(t, err, "not allowed")
+	})
+	t.Run("wrong password", func(t *testing.T) {
+		// when
+		_, err := client("queen").Get("http://" + target + "/")
+
+		// then
+		require.ErrorContains(t, err, "authentication failed")
+	})
+
+	require.Eventually(t, func() bool {
+		return sessionBytes.Load() > 0
+	}, 2*time.Second, 50*time.Millisecond, "tunnel bytes are reported")
+	srvProxy.Stop()
+}
+
+func TestServiceSOCKS5IdleClientDisconnected(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	cfg := &proxier.Config{
+		ListenPort: test_utils.GetFreePort(t),
+		Mode:       proxier.ModeSOCKS5,
+		Egress:     &proxier.EgressAllowlist{CIDRs: []string{"127.0.0.0/8"}},
+	}
+	startProxy(t, container, cfg)
+
+	// when
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(3 * proxier.ForwardHandshakeTimeout))
+	_, err = c.Read(make([]byte, 1))
+
+	// then
+	require.ErrorIs(t, err, io.EOF, "proxy closes connection of client which never sent greeting")
+}
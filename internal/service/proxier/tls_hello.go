@@ -0,0 +1,276 @@
+package proxier
+
+import (
+	"bufio"
+	"crypto/md5" //nolint:gosec // ja3 is defined as md5
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"tcp_proxy/internal/entities"
+)
+
+const (
+	tlsRecordHeaderLen  = 5
+	tlsMaxRecordLen     = 16384
+	tlsRecordHandshake  = 0x16
+	tlsClientHelloType  = 0x01
+	tlsExtServerName    = 0x0000
+	tlsExtGroups        = 0x000a
+	tlsExtPointFormats  = 0x000b
+	tlsExtSignatureAlgs = 0x000d
+	tlsExtALPN          = 0x0010
+	tlsExtVersions      = 0x002b
+
+	sniffBufferSize = tlsRecordHeaderLen + tlsMaxRecordLen // whole ClientHello record fits into peek
+)
+
+var errMalformedHello = errors.New("malformed client hello")
+
+func looksLikeTLS(br *bufio.Reader) bool {
+	b, err := br.Peek(3)
+	if err != nil {
+		return false
+	}
+	return isTLSRecord(b)
+}
+
+// peekClientHello parses ClientHello without consuming it, bytes are forwarded unchanged
+func peekClientHello(br *bufio.Reader) (*entities.TLSHello, error) {
+	header, err := br.Peek(tlsRecordHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length > tlsMaxRecordLen {
+		return nil, errMalformedHello
+	}
+	record, err := br.Peek(tlsRecordHeaderLen + length)
+	if err != nil {
+		return nil, err
+	}
+	return parseClientHello(record[tlsRecordHeaderLen:])
+}
+
+type helloReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *helloReader) bytes(n int) []byte {
+	if r.err || len(r.b) < n {
+		r.err = true
+		return nil
+	}
+	res := r.b[:n]
+	r.b = r.b[n:]
+	return res
+}
+
+func (r *helloReader) uint8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *helloReader) uint16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *helloReader) uint24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+func (r *helloReader) uint16List(n int) []int {
+	data := &helloReader{b: r.bytes(n)}
+	res := make([]int, 0, n/2)
+	for len(data.b) >= 2 {
+		res = append(res, data.uint16())
+	}
+	return res
+}
+
+// isGREASE reports reserved values (RFC 8701) clients send to keep servers tolerant, fingerprints skip them
+func isGREASE(v int) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []int) []int {
+	return slices.DeleteFunc(slices.Clone(values), isGREASE)
+}
+
+func parseClientHello(handshake []byte) (*entities.TLSHello, error) {
+	r := &helloReader{b: handshake}
+	if r.uint8() != tlsClientHelloType {
+		return nil, errMalformedHello
+	}
+	body := &helloReader{b: r.bytes(r.uint24())}
+	legacyVersion := body.uint16()
+	body.bytes(32) // random
+	body.bytes(body.uint8())
+	ciphers := body.uint16List(body.uint16())
+	body.bytes(body.uint8()) // compression methods
+	if body.err {
+		return nil, errMalformedHello
+	}
+
+	hello := &entities.TLSHello{}
+	var extensions, groups, pointFormats, signatureAlgs, versions []int
+	exts := &helloReader{b: body.bytes(body.uint16())} // absent in very old clients
+	for len(exts.b) >= 4 && !exts.err {
+		extType := exts.uint16()
+		data := &helloReader{b: exts.bytes(exts.uint16())}
+		extensions = append(extensions, extType)
+		switch extType {
+		case tlsExtServerName:
+			list := &helloReader{b: data.bytes(data.uint16())}
+			for len(list.b) > 0 && !list.err {
+				nameType, name := list.uint8(), list.bytes(list.uint16())
+				if nameType == 0 {
+					hello.ServerName = string(name)
+				}
+			}
+		case tlsExtALPN:
+			list := &helloReader{b: data.bytes(data.uint16())}
+			for len(list.b) > 0 && !list.err {
+				if proto := list.bytes(list.uint8()); proto != nil {
+					hello.ALPN = append(hello.ALPN, string(proto))
+				}
+			}
+		case tlsExtVersions:
+			versions = data.uint16List(data.uint8())
+		case tlsExtGroups:
+			groups = data.uint16List(data.uint16())
+		case tlsExtPointFormats:
+			for _, f := range data.bytes(data.uint8()) {
+				pointFormats = append(pointFormats, int(f))
+			}
+		case tlsExtSignatureAlgs:
+			signatureAlgs = data.uint16List(data.uint16())
+		}
+	}
+	if exts.err {
+		return nil, errMalformedHello
+	}
+
+	versions = withoutGREASE(versions)
+	for _, v := range versions {
+		hello.Versions = append(hello.Versions, tlsVersionName(v))
+	}
+	hello.JA3 = ja3(legacyVersion, ciphers, extensions, groups, pointFormats)
+	hello.JA4 = ja4(legacyVersion, versions, ciphers, extensions, signatureAlgs, hello)
+	return hello, nil
+}
+
+func ja3(version int, ciphers, extensions, groups, pointFormats []int) string {
+	join := func(values []int) string {
+		parts := make([]string, 0, len(values))
+		for _, v := range withoutGREASE(values) {
+			parts = append(parts, strconv.Itoa(v))
+		}
+		return strings.Join(parts, "-")
+	}
+	raw := fmt.Sprintf("%d,%s,%s,%s,%s", version, join(ciphers), join(extensions), join(groups), join(pointFormats))
+	sum := md5.Sum([]byte(raw)) //nolint:gosec // ja3 is defined as md5
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4 builds JA4 fingerprint of TLS over TCP, see https://github.com/FoxIO-LLC/ja4
+func ja4(legacyVersion int, versions, ciphers, extensions, signatureAlgs []int, hello *entities.TLSHello) string {
+	version := legacyVersion
+	if len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+	ciphers, extensions = withoutGREASE(ciphers), withoutGREASE(extensions)
+	alpn := "00"
+	if len(hello.ALPN) > 0 && hello.ALPN[0] != "" {
+		first := hello.ALPN[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+		if !isAlnum(first[0]) || !isAlnum(first[len(first)-1]) {
+			h := hex.EncodeToString([]byte(first))
+			alpn = string(h[0]) + string(h[len(h)-1])
+		}
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	sortedHex := func(values []int, skip ...int) []string {
+		res := make([]string, 0, len(values))
+		for _, v := range values {
+			if !slices.Contains(skip, v) {
+				res = append(res, fmt.Sprintf("%04x", v))
+			}
+		}
+		slices.Sort(res)
+		return res
+	}
+	b := ja4Hash(strings.Join(sortedHex(ciphers), ","))
+	c := strings.Join(sortedHex(extensions, tlsExtServerName, tlsExtALPN), ",")
+	if len(signatureAlgs) > 0 {
+		algs := make([]string, 0, len(signatureAlgs))
+		for _, v := range signatureAlgs {
+			algs = append(algs, fmt.Sprintf("%04x", v))
+		}
+		c += "_" + strings.Join(algs, ",")
+	}
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func isAlnum(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func ja4Version(v int) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+func tlsVersionName(v int) string {
+	switch v {
+	case 0x0304:
+		return "TLS 1.3"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0301:
+		return "TLS 1.0"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
@@ -0,0 +1,116 @@
+package proxier_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceTLSClientHello(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure")
+	}))
+	t.Cleanup(upstream.Close)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+	}
+	container.SrvNotificatorMock.EXPECT().SendInfoNewTLSRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, entities.ProtocolTLS, n.Protocol)
+			require.Equal(t, "rpc.example.com", n.TLS.ServerName)
+			require.Equal(t, []string{"http/1.1"}, n.TLS.ALPN)
+			require.Contains(t, n.TLS.Versions, "TLS 1.3")
+			require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), n.TLS.JA3)
+			require.Regexp(t, regexp.MustCompile(`^t13d\d{4}h1_[0-9a-f]{12}_[0-9a-f]{12}$`), n.TLS.JA4)
+			return nil
+		}).MinTimes(1)
+	srvProxy := startProxy(t, container, cfg)
+
+	// when
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig: &tls.Config{
+			ServerName:         "rpc.example.com",
+			InsecureSkipVerify: true, //nolint:gosec // test server has self-signed certificate
+			NextProtos:         []string{"http/1.1"},
+		},
+	}}
+	resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/", cfg.ListenPort))
+
+	// then
+	require.NoError(t, err, "encrypted bytes must be forwarded unchanged")
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "secure", string(data))
+	srvProxy.Stop()
+}
+
+func TestServiceSNIRoutes(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	newUpstream := func(name string) *httptest.Server {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	defaultSrv, bscSrv, rpcSrv := newUpstream("default"), newUpstream("bsc"), newUpstream("rpc")
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, defaultSrv),
+		Routes: []proxier.Route{
+			{ServerName: "*.bsc.local", DestinationAddress: "127.0.0.1", DestinationPort: upstreamPort(t, bscSrv)},
+			{ServerName: "rpc.example.com", DestinationAddress: "127.0.0.1", DestinationPort: upstreamPort(t, rpcSrv)},
+		},
+	}
+	startProxy(t, container, cfg)
+
+	table := []struct {
+		name       string
+		serverName string
+		expected   string
+	}{
+		{name: "exact server name", serverName: "rpc.example.com", expected: "rpc"},
+		{name: "wildcard server name", serverName: "node1.bsc.local", expected: "bsc"},
+		{name: "default backend", serverName: "other.example.com", expected: "default"},
+		{name: "no server name", expected: "default"},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			client := &http.Client{Transport: &http.Transport{
+				DisableKeepAlives: true,
+				TLSClientConfig: &tls.Config{
+					ServerName:         tc.serverName,
+					InsecureSkipVerify: true, //nolint:gosec // test server has self-signed certificate
+				},
+			}}
+			resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/", cfg.ListenPort))
+
+			// then
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tc.expected, string(data))
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package proxier
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	defaultCertReloadInterval = 10 * time.Second
+	tlsHandshakeTimeout       = 10 * time.Second
+)
+
+// TLSConfig enables TLS termination on the listener, decrypted stream goes through the regular sniffing
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file"`
+	KeyFile        string        `yaml:"key_file"`
+	MinVersion     string        `yaml:"min_version"`     // 1.0, 1.1, 1.2 (default) or 1.3
+	CipherSuites   []string      `yaml:"cipher_suites"`   // crypto/tls names, TLS 1.3 suites are not configurable
+	ALPN           []string      `yaml:"alpn"`            // default http/1.1, add h2 only if upstream speaks h2c (gRPC)
+	ReloadInterval time.Duration `yaml:"reload_interval"` // how often certificate files are checked for changes
+
+	ClientCAFile       string `yaml:"client_ca_file"`       // enables client certificate verification
+	ClientAuth         string `yaml:"client_auth"`          // require (default) or request
+	RevokedSerialsFile string `yaml:"revoked_serials_file"` // hex serials of rejected client certificates, reloaded on change
+}
+
+func (c *TLSConfig) reloadInterval() time.Duration {
+	if c.ReloadInterval <= 0 {
+		return defaultCertReloadInterval
+	}
+	return c.ReloadInterval
+}
+
+// certReloader keeps the latest certificate from disk, handshakes always see a complete key pair
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time // last loaded files state, accessed by single goroutine
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads key pair if any of the files changed since the previous load
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if modTime.Equal(r.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error load certificate: %w", err) // files may be in the middle of update, retry on next check
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return true, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error stat file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func newServerTLSConfig(conf *TLSConfig, certs *certReloader, revoked *revokedSerials) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(conf.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(conf.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	alpn := conf.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"http/1.1"}
+	}
+	tlsConf := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		NextProtos:     alpn,
+		GetCertificate: certs.getCertificate,
+	}
+	if conf.ClientCAFile != "" {
+		if tlsConf.ClientCAs, err = loadCertPool(conf.ClientCAFile); err != nil {
+			return nil, err
+		}
+		if tlsConf.ClientAuth, err = parseClientAuth(conf.ClientAuth); err != nil {
+			return nil, err
+		}
+	} else if conf.ClientAuth != "" {
+		return nil, fmt.Errorf("client_auth requires client_ca_file")
+	}
+	if revoked != nil {
+		tlsConf.VerifyConnection = revoked.verifyConnection
+	}
+	return tlsConf, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version: %s", version)
+	}
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil // go defaults
+	}
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// terminateTLS completes server handshake, returned connection carries decrypted stream
+func (s *Service) terminateTLS(l logger.AppLogger, c net.Conn) (logger.AppLogger, *tls.Conn, error) {
+	tlsConn := tls.Server(c, s.tlsConf)
+	_ = c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.HandshakeContext(s.ctx); err != nil {
+		return l, nil, fmt.Errorf("error tls handshake: %w", err)
+	}
+	_ = c.SetDeadline(time.Time{})
+	state := tlsConn.ConnectionState()
+	l = l.With(
+		logger.WithString("sni", state.ServerName),
+		logger.WithString("alpn", state.NegotiatedProtocol),
+		logger.WithString("tls_version", tls.VersionName(state.Version)),
+	)
+	if identity := peerIdentity(tlsConn); identity != nil {
+		l = l.With(
+			logger.WithString("client_subject", identity.Subject),
+			logger.WithString("client_sans", strings.Join(identity.SANs, ",")),
+			logger.WithString("client_fingerprint", identity.Fingerprint),
+		)
+	}
+	return l, tlsConn, nil
+}
+
+func (s *Service) bgReloadTLSFiles() {
+	ticker := time.NewTicker(s.conf.TLS.reloadInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.reloadTLSFiles()
+		}
+	}
+}
+
+func (s *Service) reloadTLSFiles() {
+	if reloaded, err := s.certs.reload(); err != nil {
+		s.log.Error("failed to reload tls certificate", err)
+	} else if reloaded {
+		s.log.Info("tls certificate reloaded")
+	}
+	if s.revoked == nil {
+		return
+	}
+	if reloaded, err := s.revoked.reload(); err != nil {
+		s.log.Error("failed to reload revoked serials", err)
+	} else if reloaded {
+		s.log.Info("revoked serials reloaded")
+	}
+}
@@ -0,0 +1,138 @@
+package proxier_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestServiceTLSTermination(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "plain")
+	}))
+	t.Cleanup(upstream.Close)
+	cert := test_utils.SelfSignedCert(t)
+	certFile, keyFile := test_utils.WriteCertificate(t, t.TempDir(), cert)
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		NotifyHTTP:         true,
+		TLS:                &proxier.TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"},
+	}
+	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, "/eth/blocks", n.RemoteURL)
+			return nil
+		}).MinTimes(1)
+	srvProxy := startProxy(t, container, cfg)
+
+	// when
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+	}}
+	resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/eth/blocks", cfg.ListenPort))
+
+	// then
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "plain", string(data))
+	require.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	srvProxy.Stop()
+}
+
+func TestServiceTLSTerminationGRPC(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	grpcSrv := test_utils.NewTestGRPCServer(t, test_utils.SelfSignedCert(t), false)
+	certFile, keyFile := test_utils.WriteCertificate(t, t.TempDir(), test_utils.SelfSignedCert(t))
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    grpcSrv.GetDestinationPort(),
+		NotifyHTTP:         true,
+		TLS:                &proxier.TLSConfig{CertFile: certFile, KeyFile: keyFile, ALPN: []string{"h2"}},
+	}
+	container.SrvNotificatorMock.EXPECT().SendInfoNewGRPCRequest(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, test_utils.PingMethod, n.RemoteURL)
+			require.Equal(t, "0", n.Status)
+			return nil
+		}).MinTimes(1)
+	srvProxy := startProxy(t, container, cfg)
+
+	// when
+	creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}) //nolint:gosec // test certificate
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	var out wrapperspb.BytesValue
+	err = conn.Invoke(container.Ctx, test_utils.PingMethod, &wrapperspb.BytesValue{}, &out)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(out.Value))
+	srvProxy.Stop()
+}
+
+func TestServiceTLSCertificateReload(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(upstream.Close)
+	dir := t.TempDir()
+	certFile, keyFile := test_utils.WriteCertificate(t, dir, test_utils.SelfSignedCert(t))
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		TLS:                &proxier.TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 20 * time.Millisecond},
+	}
+	startProxy(t, container, cfg)
+	servedSerial := func() *big.Int {
+		c, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), &tls.Config{InsecureSkipVerify: true}) //nolint:gosec // test certificate
+		require.NoError(t, err)
+		defer c.Close()
+		return c.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+	initial := servedSerial()
+
+	// when
+	renewed := test_utils.SelfSignedCert(t)
+	test_utils.WriteCertificate(t, dir, renewed)
+	future := time.Now().Add(time.Minute) // make sure mtime changes on coarse filesystems
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	// then
+	leaf, err := x509.ParseCertificate(renewed.Certificate[0])
+	require.NoError(t, err)
+	require.NotEqual(t, initial, leaf.SerialNumber)
+	require.Eventually(t, func() bool {
+		return servedSerial().Cmp(leaf.SerialNumber) == 0
+	}, 2*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,172 @@
+package proxier
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+
+	defaultUDPSessionTimeout = time.Minute
+	defaultMaxUDPSessions    = 10_000
+	udpMaxDatagramSize       = 65535
+)
+
+// udpSession is NAT-like mapping of one client address to own upstream socket,
+// so replies of the upstream can be returned to the right client
+type udpSession struct {
+	log        logger.AppLogger
+	clientAddr net.Addr
+	server     *net.UDPConn
+	startedAt  time.Time
+	lastSeen   atomic.Int64 // unix nano of the last datagram in any direction
+
+	packetsIn  atomic.Int64 // client to upstream
+	packetsOut atomic.Int64 // upstream to client
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+}
+
+func (u *udpSession) touch() {
+	u.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (s *Service) udpSessionTimeout() time.Duration {
+	if s.conf.UDPSessionTimeout <= 0 {
+		return defaultUDPSessionTimeout
+	}
+	return s.conf.UDPSessionTimeout
+}
+
+func (s *Service) maxUDPSessions() int {
+	if s.conf.MaxUDPSessions <= 0 {
+		return defaultMaxUDPSessions
+	}
+	return s.conf.MaxUDPSessions
+}
+
+// serveUDP forwards datagrams until listener is closed by Stop.
+// sessions are removed and closed under lock, so datagram of the client is never written to closed socket
+func (s *Service) serveUDP(listener net.PacketConn) {
+	serverAddr, err := net.ResolveUDPAddr("udp", s.upstream.addr)
+	if err != nil {
+		s.log.Fatal("failed to resolve destination", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		sessions = make(map[string]*udpSession)
+	)
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		n, clientAddr, errR := listener.ReadFrom(buf)
+		if errR != nil {
+			if errors.Is(errR, net.ErrClosed) {
+				return
+			}
+			s.log.Error("failed to read datagram", errR)
+			continue
+		}
+		mu.Lock()
+		session, ok := sessions[clientAddr.String()]
+		if !ok && len(sessions) >= s.maxUDPSessions() { // source addresses are trivial to spoof
+			mu.Unlock()
+			if s.udpDropped.Add(1) == 1 {
+				s.log.Info("udp session limit reached, datagrams of new sources are dropped", logger.WithInt("max_udp_sessions", s.maxUDPSessions()))
+			}
+			continue
+		}
+		if !ok {
+			server, errD := net.DialUDP("udp", nil, serverAddr)
+			if errD != nil {
+				mu.Unlock()
+				s.log.Error("failed to connect to remote server", errD)
+				continue
+			}
+			session = &udpSession{
+				log:        s.log.With(logger.WithString("remote_ip", clientAddr.String())),
+				clientAddr: clientAddr,
+				server:     server,
+				startedAt:  time.Now(),
+			}
+			session.touch()
+			sessions[clientAddr.String()] = session
+			session.log.Info("accepted new udp session")
+			go func() {
+				s.forwardUDPReplies(listener, session)
+				mu.Lock()
+				delete(sessions, clientAddr.String())
+				_ = session.server.Close()
+				mu.Unlock()
+				s.finishUDPSession(session)
+			}()
+		}
+		session.touch()
+		_, errW := session.server.Write(buf[:n])
+		mu.Unlock()
+		if errW != nil {
+			session.log.Error("failed to forward datagram", errW)
+			continue
+		}
+		session.packetsIn.Add(1)
+		session.bytesIn.Add(int64(n))
+	}
+}
+
+// forwardUDPReplies returns upstream datagrams to the client until session is idle for too long
+func (s *Service) forwardUDPReplies(listener net.PacketConn, session *udpSession) {
+	timeout := s.udpSessionTimeout()
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		deadline := time.Unix(0, session.lastSeen.Load()).Add(timeout)
+		_ = session.server.SetReadDeadline(deadline)
+		n, err := session.server.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if time.Since(time.Unix(0, session.lastSeen.Load())) >= timeout {
+				return
+			}
+			continue // client sent something meanwhile
+		}
+		if err != nil {
+			session.log.Error("failed to read upstream datagram", err)
+			return
+		}
+		session.touch()
+		if _, err = listener.WriteTo(buf[:n], session.clientAddr); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			session.log.Error("failed to return datagram", err)
+			continue
+		}
+		session.packetsOut.Add(1)
+		session.bytesOut.Add(int64(n))
+	}
+}
+
+func (s *Service) finishUDPSession(session *udpSession) {
+	session.log.Info("udp session finished",
+		logger.WithInt64("packets_received", session.packetsIn.Load()),
+		logger.WithInt64("packets_sent", session.packetsOut.Load()),
+		logger.WithInt64("bytes_received", session.bytesIn.Load()),
+		logger.WithInt64("bytes_sent", session.bytesOut.Load()),
+		logger.WithString("duration", time.Since(session.startedAt).Round(time.Second).String()),
+	)
+	if s.conf.NotifyHTTP {
+		s.trackEvent(&entities.Notification{
+			Protocol:    entities.ProtocolUDP,
+			RemoteIP:    session.clientAddr.String(),
+			Destination: s.upstream.addr,
+			Packets:     session.packetsIn.Load() + session.packetsOut.Load(),
+			Bytes:       session.bytesIn.Load() + session.bytesOut.Load(),
+		})
+	}
+}
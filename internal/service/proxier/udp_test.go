@@ -0,0 +1,139 @@
+package proxier_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceUDP(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	echo, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = echo.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, errR := echo.ReadFrom(buf)
+			if errR != nil {
+				return
+			}
+			_, _ = echo.WriteTo(append([]byte("echo:"), buf[:n]...), addr)
+		}
+	}()
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    echo.LocalAddr().(*net.UDPAddr).Port,
+		NotifyHTTP:         true,
+		Protocol:           proxier.ProtocolUDP,
+		UDPSessionTimeout:  200 * time.Millisecond,
+	}
+	sessions := make(chan *entities.Notification, 1)
+	container.SrvNotificatorMock.EXPECT().SendInfoNewSession(gomock.Any(), gomock.Any(), 1).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			sessions <- n
+			return nil
+		}).Times(1)
+	srvProxy := proxier.NewService(container.Ctx, cfg, container.Log, container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+
+	// when
+	c, err := net.Dial("udp4", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	defer c.Close()
+	buf := make([]byte, 1500)
+	require.Eventually(t, func() bool { // listener may be not ready yet, datagram is lost then
+		_, _ = c.Write([]byte("ping"))
+		_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, errR := c.Read(buf)
+		return errR == nil && string(buf[:n]) == "echo:ping"
+	}, 2*time.Second, 10*time.Millisecond)
+	for _, msg := range []string{"eth", "bsc"} {
+		_, err = c.Write([]byte(msg))
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		n, errR := c.Read(buf)
+		require.NoError(t, errR)
+		require.Equal(t, "echo:"+msg, string(buf[:n]))
+	}
+
+	// then
+	select {
+	case n := <-sessions:
+		require.Equal(t, entities.ProtocolUDP, n.Protocol)
+		require.Equal(t, c.LocalAddr().String(), n.RemoteIP)
+		require.GreaterOrEqual(t, n.Packets, int64(6))
+		require.Zero(t, n.Packets%2, "every datagram is answered")
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not expired")
+	}
+}
+
+func TestServiceUDPSessionLimit(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	echo, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = echo.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, errR := echo.ReadFrom(buf)
+			if errR != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    echo.LocalAddr().(*net.UDPAddr).Port,
+		Protocol:           proxier.ProtocolUDP,
+		MaxUDPSessions:     1,
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	dial := func() net.Conn {
+		c, errD := net.Dial("udp4", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+		require.NoError(t, errD)
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	first, second := dial(), dial()
+	buf := make([]byte, 1500)
+	require.Eventually(t, func() bool {
+		_, _ = first.Write([]byte("ping"))
+		_ = first.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, errR := first.Read(buf)
+		return errR == nil && string(buf[:n]) == "ping"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// when
+	_, err = second.Write([]byte("ping"))
+	require.NoError(t, err)
+	_ = second.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = second.Read(buf)
+
+	// then
+	require.Error(t, err, "new source above the limit gets no session")
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"got dropped udp datagrams of new sources"`)
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Contains(t, logs.String(), `"_max_udp_sessions":"1"`)
+}
@@ -0,0 +1,116 @@
+package proxier
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const unixScheme = "unix://"
+
+// UnixSocketConfig sets permissions of listen sockets given as unix:///path
+type UnixSocketConfig struct {
+	Mode  string `yaml:"mode"`  // octal, for example 0660
+	User  string `yaml:"user"`  // name or uid
+	Group string `yaml:"group"` // name or gid
+}
+
+// splitUnixAddress returns network and address to dial or listen, unix:///path selects unix socket
+func splitUnixAddress(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// destinationAddress joins host and port, port is meaningless for unix socket
+func destinationAddress(address string, port int) string {
+	if strings.HasPrefix(address, unixScheme) {
+		return address
+	}
+	return fmt.Sprintf("%s:%d", address, port)
+}
+
+// listenUnix replaces stale socket file left by previous run and applies configured permissions
+func (s *Service) listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listen unix socket: %w", err)
+	}
+	if err = s.conf.UnixSocket.apply(path); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (c *UnixSocketConfig) apply(path string) error {
+	if c.Mode != "" {
+		mode, err := strconv.ParseUint(c.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("error parse socket mode: %w", err)
+		}
+		if err = os.Chmod(path, fs.FileMode(mode)); err != nil {
+			return fmt.Errorf("error chmod socket: %w", err)
+		}
+	}
+	if c.User == "" && c.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1 // keep unchanged
+	if c.User != "" {
+		id, err := lookupID(c.User, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("error lookup socket user: %w", err)
+		}
+		uid = id
+	}
+	if c.Group != "" {
+		id, err := lookupID(c.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("error lookup socket group: %w", err)
+		}
+		gid = id
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("error chown socket: %w", err)
+	}
+	return nil
+}
+
+// lookupID accepts numeric id or resolves name
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	raw, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("non numeric id: %s", raw)
+	}
+	return id, nil
+}
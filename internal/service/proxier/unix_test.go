@@ -0,0 +1,99 @@
+package proxier_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceUnixSocketUpstream(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	socket := filepath.Join(t.TempDir(), "geth.ipc")
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	upstream := &http.Server{ReadHeaderTimeout: time.Second, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ipc")
+	})}
+	go func() { _ = upstream.Serve(ln) }()
+	t.Cleanup(func() { _ = upstream.Close() })
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "unix://" + socket,
+		NotifyHTTP:         true,
+	}
+	container.SrvNotificatorMock.EXPECT().SendInfoNewRequest(gomock.Any(), "unix://"+socket, gomock.Any()).
+		DoAndReturn(func(n *entities.Notification, _ string, _ int) error {
+			require.Equal(t, "/eth/", n.RemoteURL)
+			return nil
+		}).MinTimes(1)
+	srvProxy := startProxy(t, container, cfg)
+
+	// when
+	code, body := plainGet(t, "http://127.0.0.1:"+strconv.Itoa(cfg.ListenPort)+"/eth/")
+
+	// then
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ipc", body)
+	srvProxy.Stop()
+}
+
+func TestServiceUnixSocketListener(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("X-Api-Key"))
+	}))
+	t.Cleanup(upstream.Close)
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	cfg := &proxier.Config{
+		ListenAddress:      proxier.ListenAddresses{"unix://" + socket},
+		UnixSocket:         proxier.UnixSocketConfig{Mode: "0600", User: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())},
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort(t, upstream),
+		HeaderRules:        proxier.HeaderRules{Set: map[string]string{"X-Api-Key": "secret"}},
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, container.Log, container.SrvNotificatorMock)
+	go srvProxy.Start()
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	// when
+	var (
+		resp *http.Response
+		err  error
+	)
+	require.Eventually(t, func() bool {
+		resp, err = client.Get("http://proxy/")
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// then
+	require.Equal(t, "secret", string(data), "http sniffing works over unix socket")
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	srvProxy.Stop()
+	_, err = os.Stat(socket)
+	require.True(t, os.IsNotExist(err), "socket file is removed on stop")
+}
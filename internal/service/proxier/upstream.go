@@ -0,0 +1,120 @@
+package proxier
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	upstreamStageDial         = "dial"
+	upstreamStageTLSHandshake = "tls_handshake"
+)
+
+var errUpstreamHandshake = errors.New("upstream tls handshake failed")
+
+// UpstreamTLSConfig originates TLS toward destination, while clients keep speaking plaintext to the proxy
+type UpstreamTLSConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	ServerName         string   `yaml:"server_name"` // SNI and verified name, destination host by default, required for unix://
+	CAFile             string   `yaml:"ca_file"`     // system roots if empty
+	CertFile           string   `yaml:"cert_file"`   // client certificate for mutual tls
+	KeyFile            string   `yaml:"key_file"`
+	ALPN               []string `yaml:"alpn"`
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"` // labs only
+}
+
+// upstream is a destination with its dial options
+type upstream struct {
+	addr          string // host:port or unix:///path
+	tlsConf       *tls.Config
+	proxyProtocol string // PROXY header version sent before forwarded bytes
+}
+
+func newUpstream(address string, port int, conf *UpstreamTLSConfig, proxyProtocol string) (*upstream, error) {
+	u := &upstream{addr: destinationAddress(address, port), proxyProtocol: proxyProtocol}
+	switch proxyProtocol {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+	default:
+		return nil, fmt.Errorf("unknown proxy protocol version: %s", proxyProtocol)
+	}
+	if conf == nil || !conf.Enabled {
+		return u, nil
+	}
+	serverName := conf.ServerName
+	if serverName == "" && strings.HasPrefix(address, unixScheme) {
+		return nil, errors.New("upstream_tls.server_name is required for unix socket destination")
+	}
+	if serverName == "" {
+		serverName = address
+	}
+	u.tlsConf = &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         conf.ALPN,
+		InsecureSkipVerify: conf.InsecureSkipVerify, //nolint:gosec // explicitly configured for labs
+		MinVersion:         tls.VersionTLS12,
+	}
+	if conf.CAFile != "" {
+		pool, err := loadCertPool(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		u.tlsConf.RootCAs = pool
+	}
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error load upstream client certificate: %w", err)
+		}
+		u.tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return u, nil
+}
+
+// dialDestination connects to upstream on behalf of client, TLS handshake errors are wrapped with errUpstreamHandshake
+func (s *Service) dialDestination(u *upstream, client net.Conn) (net.Conn, error) {
+	network, address := splitUnixAddress(u.addr)
+	conn, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error dial destination: %w", err)
+	}
+	if u.proxyProtocol != "" {
+		if err = writeProxyHeader(conn, u.proxyProtocol, client.RemoteAddr(), client.LocalAddr()); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if u.tlsConf == nil {
+		return conn, nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, tlsHandshakeTimeout)
+	defer cancel()
+	tlsConn := tls.Client(conn, u.tlsConf)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %w", errUpstreamHandshake, err)
+	}
+	return tlsConn, nil
+}
+
+type upstreamFailure struct {
+	destination string
+	stage       string
+}
+
+// handleDialFailure logs and counts dial and handshake failures apart, they need different fixes
+func (s *Service) handleDialFailure(l logger.AppLogger, u *upstream, err error) {
+	stage, message := upstreamStageDial, "failed to connect to remote server"
+	if errors.Is(err, errUpstreamHandshake) {
+		stage, message = upstreamStageTLSHandshake, "failed tls handshake with remote server"
+	}
+	l.Error(message, err, logger.WithString("destination", u.addr))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstreamFailures[upstreamFailure{destination: u.addr, stage: stage}]++
+}
@@ -0,0 +1,161 @@
+package proxier_test
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServiceUpstreamTLS(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	dir := t.TempDir()
+	secureSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure")
+	}))
+	t.Cleanup(secureSrv.Close)
+	secureCA := filepath.Join(dir, "upstream_ca.pem")
+	require.NoError(t, os.WriteFile(secureCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secureSrv.Certificate().Raw}), 0o600))
+
+	ca := test_utils.NewTestCA(t)
+	mutualSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	mutualSrv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, "upstream.local", 1)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.Pool(),
+		MinVersion:   tls.VersionTLS12,
+	}
+	mutualSrv.StartTLS()
+	t.Cleanup(mutualSrv.Close)
+	clientCertFile, clientKeyFile := test_utils.WriteCertificate(t, dir, ca.Issue(t, "proxy", 2))
+
+	table := []struct {
+		name          string
+		port          int
+		upstreamTLS   *proxier.UpstreamTLSConfig
+		expectedCode  int
+		expectedBody  string
+		expectedStage string // stage of upstream failure, empty if request succeeds
+	}{
+		{
+			name:         "verified upstream",
+			port:         upstreamPort(t, secureSrv),
+			upstreamTLS:  &proxier.UpstreamTLSConfig{Enabled: true, CAFile: secureCA},
+			expectedCode: http.StatusOK,
+			expectedBody: "secure",
+		},
+		{
+			name: "mutual tls upstream",
+			port: upstreamPort(t, mutualSrv),
+			upstreamTLS: &proxier.UpstreamTLSConfig{
+				Enabled: true, ServerName: "upstream.local", CAFile: ca.WriteCA(t, dir), CertFile: clientCertFile, KeyFile: clientKeyFile,
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "proxy",
+		},
+		{
+			name:         "insecure skip verify",
+			port:         upstreamPort(t, secureSrv),
+			upstreamTLS:  &proxier.UpstreamTLSConfig{Enabled: true, InsecureSkipVerify: true},
+			expectedCode: http.StatusOK,
+			expectedBody: "secure",
+		},
+		{
+			name:          "untrusted upstream",
+			port:          upstreamPort(t, secureSrv),
+			upstreamTLS:   &proxier.UpstreamTLSConfig{Enabled: true},
+			expectedCode:  http.StatusBadGateway,
+			expectedStage: "tls_handshake",
+		},
+		{
+			name:          "unreachable upstream",
+			port:          test_utils.GetFreePort(t),
+			upstreamTLS:   &proxier.UpstreamTLSConfig{Enabled: true},
+			expectedCode:  http.StatusBadGateway,
+			expectedStage: "dial",
+		},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := &syncBuffer{}
+			cfg := &proxier.Config{
+				ListenPort:         test_utils.GetFreePort(t),
+				DestinationAddress: "127.0.0.1",
+				DestinationPort:    tc.port,
+				HeaderRules:        proxier.HeaderRules{ForwardedFor: true},
+				UpstreamTLS:        tc.upstreamTLS,
+			}
+			notified := make(chan struct{}, 1)
+			if tc.expectedStage != "" {
+				destination := fmt.Sprintf("127.0.0.1:%d", tc.port)
+				container.SrvNotificatorMock.EXPECT().SendInfoUpstreamFailed(destination, tc.expectedStage, gomock.Any()).
+					DoAndReturn(func(_, _ string, _ int) error {
+						select {
+						case notified <- struct{}{}:
+						default: // readiness probe fails too and may land in another dump
+						}
+						return nil
+					}).MinTimes(1)
+			}
+			srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+			go srvProxy.Start()
+			require.Eventually(t, func() bool {
+				c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+				if err != nil {
+					return false
+				}
+				_ = c.Close()
+				return true
+			}, 2*time.Second, 20*time.Millisecond)
+
+			// when
+			code, body := plainGet(t, fmt.Sprintf("http://127.0.0.1:%d/", cfg.ListenPort))
+
+			// then
+			require.Equal(t, tc.expectedCode, code)
+			require.Equal(t, tc.expectedBody, body)
+			if tc.expectedStage != "" {
+				select {
+				case <-notified:
+				case <-time.After(2 * time.Second):
+					t.Fatal("upstream failure was not notified")
+				}
+				require.Eventually(t, func() bool {
+					return strings.Contains(logs.String(), `"_stage":"`+tc.expectedStage+`"`)
+				}, time.Second, 20*time.Millisecond)
+			}
+			srvProxy.Stop()
+		})
+	}
+}
+
+func plainGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, ""
+	}
+	return resp.StatusCode, string(data)
+}
@@ -0,0 +1,306 @@
+package proxier
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"tcp_proxy/internal/logger"
+	"time"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+
+	wsMaxInspectedMessage = 1 << 20 // bigger messages are counted but not decoded
+	wsMaxPendingSubscribe = 1_000   // unanswered eth_subscribe calls of connection, client is disconnected above it
+)
+
+var errWSPendingSubscribe = errors.New("too many pending websocket subscriptions")
+
+func isWebsocketUpgrade(req *http.Request, resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// pipeWebsocket passes frames untouched in both directions, decoding copies of them for stats
+func (s *Service) pipeWebsocket(l logger.AppLogger, c net.Conn, br *bufio.Reader, server net.Conn, serverBr *bufio.Reader, req *http.Request, resp *http.Response) {
+	l = l.With(logger.WithString("path", req.URL.Path))
+	l.Info("websocket upgraded",
+		logger.WithString("protocol", resp.Header.Get("Sec-Websocket-Protocol")),
+		logger.WithString("extensions", resp.Header.Get("Sec-Websocket-Extensions")),
+	)
+	stats := &wsStats{started: time.Now(), pendingSubscribe: make(map[string]string)}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(DumpNotificationsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				stats.log(l, "websocket stats")
+			}
+		}
+	}()
+
+	pipe(c, io.TeeReader(br, &wsFrameParser{stats: stats, fromClient: true}), server, io.TeeReader(serverBr, &wsFrameParser{stats: stats}))
+	if err := stats.failure(); err != nil {
+		l.Info("websocket closed by proxy", logger.WithString("reason", err.Error()))
+	}
+	stats.log(l, "websocket closed")
+}
+
+type wsStats struct {
+	started time.Time
+
+	mu               sync.Mutex
+	clientFrames     int64
+	serverFrames     int64
+	clientMessages   int64
+	serverMessages   int64
+	subscriptions    int64 // confirmed eth_subscribe calls
+	unsubscriptions  int64
+	notifications    int64 // eth_subscription messages pushed by server
+	pendingSubscribe map[string]string
+	err              error // connection is closed because of it
+}
+
+func (st *wsStats) failure() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err
+}
+
+func (st *wsStats) log(l logger.AppLogger, message string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	elapsed := time.Since(st.started)
+	l.Info(message,
+		logger.WithInt64("client_frames", st.clientFrames),
+		logger.WithInt64("server_frames", st.serverFrames),
+		logger.WithInt64("client_messages", st.clientMessages),
+		logger.WithInt64("server_messages", st.serverMessages),
+		logger.WithInt64("subscriptions", st.subscriptions),
+		logger.WithInt64("unsubscriptions", st.unsubscriptions),
+		logger.WithInt64("notifications", st.notifications),
+		logger.WithFloat64("messages_per_second", float64(st.clientMessages+st.serverMessages)/elapsed.Seconds()),
+		logger.WithString("duration", elapsed.Round(time.Second).String()),
+	)
+}
+
+type wsRPCMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (st *wsStats) handleMessage(fromClient bool, opcode byte, payload []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if fromClient {
+		st.clientMessages++
+	} else {
+		st.serverMessages++
+	}
+	if opcode != wsOpText || payload == nil {
+		return nil
+	}
+	var msg wsRPCMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil // batches and non json-rpc payloads are only counted
+	}
+	switch {
+	case fromClient && msg.Method == "eth_subscribe":
+		var params []any
+		_ = json.Unmarshal(msg.Params, &params)
+		kind := ""
+		if len(params) > 0 {
+			kind, _ = params[0].(string)
+		}
+		if _, ok := st.pendingSubscribe[string(msg.ID)]; !ok && len(st.pendingSubscribe) >= wsMaxPendingSubscribe {
+			st.err = errWSPendingSubscribe
+			return st.err
+		}
+		st.pendingSubscribe[string(msg.ID)] = kind
+	case fromClient && msg.Method == "eth_unsubscribe":
+		st.unsubscriptions++
+	case !fromClient && msg.Method == "eth_subscription":
+		st.notifications++
+	case !fromClient && len(msg.ID) > 0:
+		if _, ok := st.pendingSubscribe[string(msg.ID)]; ok {
+			delete(st.pendingSubscribe, string(msg.ID))
+			if len(msg.Result) > 0 && string(msg.Result) != "null" {
+				st.subscriptions++
+			}
+		}
+	}
+	return nil
+}
+
+func (st *wsStats) handleFrame(fromClient bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if fromClient {
+		st.clientFrames++
+	} else {
+		st.serverFrames++
+	}
+}
+
+// wsFrameParser decodes websocket frames from the stream written into it.
+// on malformed stream it just stops decoding, write fails only if connection has to be closed
+type wsFrameParser struct {
+	stats      *wsStats
+	fromClient bool
+	broken     bool
+	err        error
+
+	header    []byte
+	remaining uint64
+	opcode    byte
+	fin       bool
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	msgOpcode byte
+	msg       []byte
+	msgSkip   bool // compressed or too big message
+}
+
+func (p *wsFrameParser) Write(data []byte) (int, error) {
+	n := len(data)
+	for len(data) > 0 && !p.broken {
+		if p.remaining == 0 {
+			data = p.readHeader(data)
+			continue
+		}
+		chunk := data[:min(uint64(len(data)), p.remaining)]
+		data = data[len(chunk):]
+		p.remaining -= uint64(len(chunk))
+		p.consumePayload(chunk)
+		if p.remaining == 0 {
+			p.finishFrame()
+		}
+	}
+	return n, p.err
+}
+
+// readHeader accumulates frame header, returns rest of data
+func (p *wsFrameParser) readHeader(data []byte) []byte {
+	for len(data) > 0 {
+		p.header = append(p.header, data[0])
+		data = data[1:]
+		size, complete := wsHeaderSize(p.header)
+		if !complete || len(p.header) < size {
+			continue
+		}
+		p.parseHeader()
+		if p.remaining == 0 {
+			p.finishFrame() // empty frame
+		}
+		return data
+	}
+	return data
+}
+
+// wsHeaderSize returns full size of header once enough bytes are known
+func wsHeaderSize(h []byte) (int, bool) {
+	if len(h) < 2 {
+		return 0, false
+	}
+	size := 2
+	switch h[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+func (p *wsFrameParser) parseHeader() {
+	h := p.header
+	p.header = nil
+	p.fin = h[0]&0x80 != 0
+	p.opcode = h[0] & 0x0f
+	p.masked = h[1]&0x80 != 0
+	p.maskPos = 0
+	pos := 2
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		p.remaining = uint64(binary.BigEndian.Uint16(h[2:4]))
+		pos = 4
+	case 127:
+		p.remaining = binary.BigEndian.Uint64(h[2:10])
+		pos = 10
+	default:
+		p.remaining = uint64(l)
+	}
+	if p.masked {
+		copy(p.mask[:], h[pos:pos+4])
+	}
+	if p.opcode >= wsOpClose {
+		return // control frame can be injected in the middle of fragmented message
+	}
+	if p.opcode != wsOpContinuation {
+		p.msgOpcode = p.opcode
+		p.msg = p.msg[:0]
+		p.msgSkip = h[0]&0x40 != 0 // permessage-deflate
+	} else if p.msgOpcode == wsOpContinuation {
+		p.broken = true // continuation without started message
+	}
+}
+
+func (p *wsFrameParser) consumePayload(chunk []byte) {
+	if p.opcode >= wsOpClose || p.msgSkip {
+		return // control frames payload is not inspected
+	}
+	start := len(p.msg)
+	if start+len(chunk) > wsMaxInspectedMessage {
+		p.msgSkip = true
+		p.msg = p.msg[:0]
+		return
+	}
+	p.msg = append(p.msg, chunk...)
+	if p.masked {
+		for i := start; i < len(p.msg); i++ {
+			p.msg[i] ^= p.mask[p.maskPos%4]
+			p.maskPos++
+		}
+	}
+}
+
+func (p *wsFrameParser) finishFrame() {
+	p.stats.handleFrame(p.fromClient)
+	if p.opcode >= wsOpClose || !p.fin {
+		return
+	}
+	payload := p.msg
+	if p.msgSkip {
+		payload = nil
+	}
+	if p.err = p.stats.handleMessage(p.fromClient, p.msgOpcode, payload); p.err != nil {
+		p.broken = true
+	}
+	p.msgOpcode = wsOpContinuation
+	p.msg = p.msg[:0]
+}
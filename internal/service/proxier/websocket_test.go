@@ -0,0 +1,211 @@
+package proxier_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"tcp_proxy/internal/logger"
+	"tcp_proxy/internal/service/proxier"
+	"tcp_proxy/internal/test_utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceWebsocket(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	logs := &syncBuffer{}
+	subscribeResult := wsFrame(0x1, []byte(`{"jsonrpc":"2.0","id":1,"result":"0xcd0c3e8af590364c09d0fa6a1210faf5"}`), false, true)
+	notification := wsFrame(0x1, []byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xcd0c3e8af590364c09d0fa6a1210faf5","result":{}}}`), false, true)
+	upstreamPort := newWebsocketUpstream(t, append(subscribeResult, notification...))
+
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    upstreamPort,
+		NotifyHTTP:         true,
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// when
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	_, err = io.WriteString(c, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// fragmented subscribe call with ping in the middle
+	subscribe := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	_, err = c.Write(bytes.Join([][]byte{
+		wsFrame(0x1, subscribe[:10], true, false),
+		wsFrame(0x9, []byte("ping"), true, true),
+		wsFrame(0x0, subscribe[10:], true, true),
+	}, nil))
+	require.NoError(t, err)
+
+	// then
+	received := make([]byte, len(subscribeResult)+len(notification))
+	_, err = io.ReadFull(br, received)
+	require.NoError(t, err)
+	require.Equal(t, append(subscribeResult, notification...), received, "frames must be passed untouched")
+	require.NoError(t, c.Close())
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"websocket closed"`)
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Contains(t, logs.String(), `"_subscriptions":"1"`)
+	require.Contains(t, logs.String(), `"_notifications":"1"`)
+	require.Contains(t, logs.String(), `"_client_frames":"3"`)
+	require.Contains(t, logs.String(), `"_client_messages":"1"`)
+}
+
+func TestServiceWebsocketPendingSubscribeLimit(t *testing.T) {
+	// given
+	container := test_utils.GetClean(t)
+	logs := &syncBuffer{}
+	cfg := &proxier.Config{
+		ListenPort:         test_utils.GetFreePort(t),
+		DestinationAddress: "127.0.0.1",
+		DestinationPort:    newWebsocketUpstream(t, nil), // subscriptions are never answered
+		NotifyHTTP:         true,
+	}
+	srvProxy := proxier.NewService(container.Ctx, cfg, logger.InitLogger([]io.Writer{logs}), container.SrvNotificatorMock)
+	t.Cleanup(srvProxy.Stop)
+	go srvProxy.Start()
+	require.Eventually(t, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort), 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.ListenPort))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	_, err = io.WriteString(c, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// when
+	var frames []byte
+	for id := range 1_001 {
+		frames = append(frames, wsFrame(0x1, fmt.Appendf(nil, `{"jsonrpc":"2.0","id":%d,"method":"eth_subscribe","params":["newHeads"]}`, id), true, true)...)
+	}
+	_, _ = c.Write(frames) // proxy may close connection before all frames are written
+
+	// then
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = br.ReadByte()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, os.ErrDeadlineExceeded, "proxy closes connection")
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `"short_message":"websocket closed by proxy"`)
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Contains(t, logs.String(), `"_reason":"too many pending websocket subscriptions"`)
+}
+
+// newWebsocketUpstream accepts websocket upgrade and answers with given frames to the first client frame
+func newWebsocketUpstream(t *testing.T, answer []byte) int {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				if _, errR := http.ReadRequest(br); errR != nil {
+					return
+				}
+				_, _ = io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+					"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n")
+				if _, errR := br.ReadByte(); errR != nil {
+					return
+				}
+				_, _ = c.Write(answer)
+				_, _ = io.Copy(io.Discard, br)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func wsFrame(opcode byte, payload []byte, masked, fin bool) []byte {
+	var frame bytes.Buffer
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame.WriteByte(first)
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame.WriteByte(maskBit | byte(len(payload)))
+	default:
+		frame.WriteByte(maskBit | 126)
+		_ = binary.Write(&frame, binary.BigEndian, uint16(len(payload)))
+	}
+	if !masked {
+		frame.Write(payload)
+		return frame.Bytes()
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame.Write(mask)
+	for i, b := range payload {
+		frame.WriteByte(b ^ mask[i%4])
+	}
+	return frame.Bytes()
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,256 @@
+package replayer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/utils"
+	"time"
+)
+
+const snippetLen = 256
+
+// hopHeaders are connection specific, transport of replay sets its own
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "Accept-Encoding"}
+
+type Options struct {
+	File    string        // recording written by proxy
+	Target  string        // base url, for example http://127.0.0.1:8545, requests get Host of target
+	Speed   float64       // 1 keeps recorded pacing, 2 replays twice faster, 0 sends without pauses
+	Timeout time.Duration // per request
+}
+
+type Diff struct {
+	Line           int
+	Method         string
+	URL            string
+	RecordedStatus int
+	ReplayedStatus int
+	Recorded       string
+	Replayed       string
+	Error          string
+}
+
+type LatencyStats struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+type Report struct {
+	Total           int
+	Failed          int
+	StatusDiffs     int
+	BodyDiffs       int
+	Diffs           []Diff
+	RecordedLatency LatencyStats
+	ReplayedLatency LatencyStats
+}
+
+// Service sends recorded requests one by one in recorded order, so replay is deterministic
+type Service struct {
+	opts   Options
+	client *http.Client
+}
+
+func NewService(opts Options) *Service {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Service{
+		opts: opts,
+		client: &http.Client{
+			Timeout:       opts.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (s *Service) Run(ctx context.Context) (*Report, error) {
+	file, err := os.Open(filepath.Clean(s.opts.File))
+	if err != nil {
+		return nil, fmt.Errorf("error open recording: %w", err)
+	}
+	defer file.Close()
+
+	report := &Report{}
+	var (
+		recorded, replayed []time.Duration
+		firstAt            time.Time
+		startedAt          = time.Now()
+	)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var exchange entities.RecordedExchange
+		if err = json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("error decode recording line %d: %w", line, err)
+		}
+		if firstAt.IsZero() {
+			firstAt = exchange.StartedAt
+		}
+		if err = s.wait(ctx, startedAt, exchange.StartedAt.Sub(firstAt)); err != nil {
+			return report, err
+		}
+		report.Total++
+		recorded = append(recorded, exchange.Latency)
+		latency, diff := s.replay(ctx, &exchange)
+		if latency > 0 {
+			replayed = append(replayed, latency)
+		}
+		if diff == nil {
+			continue
+		}
+		diff.Line = line
+		switch {
+		case diff.Error != "":
+			report.Failed++
+		case diff.RecordedStatus != diff.ReplayedStatus:
+			report.StatusDiffs++
+		default:
+			report.BodyDiffs++
+		}
+		report.Diffs = append(report.Diffs, *diff)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error read recording: %w", err)
+	}
+	report.RecordedLatency = latencyStats(recorded)
+	report.ReplayedLatency = latencyStats(replayed)
+	return report, nil
+}
+
+// wait keeps recorded offset of request from the first one, scaled by speed
+func (s *Service) wait(ctx context.Context, startedAt time.Time, offset time.Duration) error {
+	if s.opts.Speed <= 0 {
+		return nil
+	}
+	delay := time.Until(startedAt.Add(time.Duration(float64(offset) / s.opts.Speed)))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *Service) replay(ctx context.Context, exchange *entities.RecordedExchange) (time.Duration, *Diff) {
+	diff := &Diff{
+		Method:         exchange.Request.Method,
+		URL:            exchange.Request.URL,
+		RecordedStatus: exchange.Response.Status,
+	}
+	body, err := exchange.Request.BodyBytes()
+	if err != nil {
+		diff.Error = fmt.Sprintf("error decode request body: %s", err)
+		return 0, diff
+	}
+	req, err := http.NewRequestWithContext(ctx, exchange.Request.Method, strings.TrimSuffix(s.opts.Target, "/")+exchange.Request.URL, bytes.NewReader(body))
+	if err != nil {
+		diff.Error = fmt.Sprintf("error build request: %s", err)
+		return 0, diff
+	}
+	for key, values := range exchange.Request.Header {
+		if !slices.Contains(hopHeaders, http.CanonicalHeaderKey(key)) {
+			req.Header[key] = values
+		}
+	}
+
+	startedAt := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		diff.Error = err.Error()
+		return 0, diff
+	}
+	defer resp.Body.Close()
+	replayed, err := io.ReadAll(resp.Body)
+	latency := time.Since(startedAt)
+	if err != nil {
+		diff.Error = fmt.Sprintf("error read response: %s", err)
+		return latency, diff
+	}
+	diff.ReplayedStatus = resp.StatusCode
+	diff.Replayed = snippet(replayed)
+
+	recordedBody, err := recordedResponse(&exchange.Response)
+	if err != nil {
+		diff.Error = fmt.Sprintf("error decode recorded response: %s", err)
+		return latency, diff
+	}
+	diff.Recorded = snippet(recordedBody)
+	if resp.StatusCode != exchange.Response.Status {
+		return latency, diff
+	}
+	if exchange.Response.Truncated || utils.SameJSON(recordedBody, replayed) {
+		return latency, nil
+	}
+	return latency, diff
+}
+
+// recordedResponse returns decoded body, replay asks target for identity encoding
+func recordedResponse(m *entities.RecordedMessage) ([]byte, error) {
+	body, err := m.BodyBytes()
+	if err != nil || !strings.EqualFold(m.Header.Get("Content-Encoding"), "gzip") || m.Truncated {
+		return body, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	return LatencyStats{P50: percentile(50), P90: percentile(90), P99: percentile(99), Max: sorted[len(sorted)-1]}
+}
+
+// Print writes human readable report, at most maxDiffs diffs are listed
+func (r *Report) Print(w io.Writer, maxDiffs int) {
+	_, _ = fmt.Fprintf(w, "requests: %d, failed: %d, status diffs: %d, body diffs: %d\n", r.Total, r.Failed, r.StatusDiffs, r.BodyDiffs)
+	_, _ = fmt.Fprintf(w, "latency recorded: p50=%s p90=%s p99=%s max=%s\n", r.RecordedLatency.P50, r.RecordedLatency.P90, r.RecordedLatency.P99, r.RecordedLatency.Max)
+	_, _ = fmt.Fprintf(w, "latency replayed: p50=%s p90=%s p99=%s max=%s\n", r.ReplayedLatency.P50, r.ReplayedLatency.P90, r.ReplayedLatency.P99, r.ReplayedLatency.Max)
+	for i, diff := range r.Diffs {
+		if i == maxDiffs {
+			_, _ = fmt.Fprintf(w, "... %d more diffs\n", len(r.Diffs)-maxDiffs)
+			break
+		}
+		if diff.Error != "" {
+			_, _ = fmt.Fprintf(w, "line %d %s %s: %s\n", diff.Line, diff.Method, diff.URL, diff.Error)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "line %d %s %s: status %d -> %d\n  recorded: %s\n  replayed: %s\n",
+			diff.Line, diff.Method, diff.URL, diff.RecordedStatus, diff.ReplayedStatus, diff.Recorded, diff.Replayed)
+	}
+}
+
+func snippet(b []byte) string {
+	if len(b) > snippetLen {
+		return string(b[:snippetLen]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,107 @@
+package replayer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"tcp_proxy/internal/entities"
+	"tcp_proxy/internal/service/replayer"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	// given
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "eth_chainId"):
+			_, _ = io.WriteString(w, `{"id":1,"result":"0x1","jsonrpc":"2.0"}`) // same document, other key order
+		case strings.Contains(string(body), "eth_blockNumber"):
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x11"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(target.Close)
+	startedAt := time.Now().Add(-time.Hour)
+	file := writeRecording(t,
+		exchange(startedAt, "eth_chainId", http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`),
+		exchange(startedAt.Add(150*time.Millisecond), "eth_blockNumber", http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`),
+		exchange(startedAt.Add(300*time.Millisecond), "eth_unknown", http.StatusOK, `{}`),
+	)
+	table := []struct {
+		name        string
+		speed       float64
+		minDuration time.Duration
+		maxDuration time.Duration
+	}{
+		{name: "original speed", speed: 1, minDuration: 300 * time.Millisecond, maxDuration: time.Second},
+		{name: "scaled speed", speed: 3, minDuration: 100 * time.Millisecond, maxDuration: 290 * time.Millisecond},
+		{name: "without pauses", speed: 0, maxDuration: 100 * time.Millisecond},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			began := time.Now()
+			report, err := replayer.NewService(replayer.Options{File: file, Target: target.URL, Speed: tc.speed}).Run(context.Background())
+			elapsed := time.Since(began)
+
+			// then
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, elapsed, tc.minDuration)
+			require.Less(t, elapsed, tc.maxDuration)
+			require.Equal(t, 3, report.Total)
+			require.Equal(t, 1, report.BodyDiffs)
+			require.Equal(t, 1, report.StatusDiffs)
+			require.Len(t, report.Diffs, 2)
+			require.Equal(t, 2, report.Diffs[0].Line)
+			require.Contains(t, report.Diffs[0].Replayed, "0x11")
+			require.Equal(t, http.StatusNotFound, report.Diffs[1].ReplayedStatus)
+			require.Equal(t, 50*time.Millisecond, report.RecordedLatency.P50)
+			require.Positive(t, report.ReplayedLatency.Max)
+
+			var out bytes.Buffer
+			report.Print(&out, 1)
+			require.Contains(t, out.String(), "requests: 3, failed: 0, status diffs: 1, body diffs: 1")
+			require.Contains(t, out.String(), "... 1 more diffs")
+		})
+	}
+}
+
+func exchange(startedAt time.Time, method string, status int, response string) *entities.RecordedExchange {
+	e := &entities.RecordedExchange{
+		StartedAt: startedAt,
+		Latency:   50 * time.Millisecond,
+		Request: entities.RecordedMessage{
+			Method: http.MethodPost,
+			URL:    "/eth/",
+			Header: http.Header{"Content-Type": {"application/json"}, "Accept-Encoding": {"gzip"}},
+		},
+		Response: entities.RecordedMessage{Status: status, Header: http.Header{}},
+	}
+	e.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`))
+	e.Response.SetBody([]byte(response))
+	return e
+}
+
+func writeRecording(t *testing.T, exchanges ...*entities.RecordedExchange) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, e := range exchanges {
+		line, err := json.Marshal(e)
+		require.NoError(t, err)
+		buf.Write(append(line, '\n'))
+	}
+	file := filepath.Join(t.TempDir(), "recording.jsonl")
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0o600))
+	return file
+}
@@ -31,7 +31,10 @@ type TestGRPCServer struct {
 	tlsConf *tls.Config
 }
 
-const EchoMethod = "/test.EchoService/EchoBytes"
+const (
+	EchoMethod = "/test.EchoService/EchoBytes"
+	PingMethod = "/test.EchoService/Ping"
+)
 
 func NewTestGRPCServer(t *testing.T, cert tls.Certificate, secureConnection bool) *TestGRPCServer {
 	t.Helper()
@@ -74,6 +77,16 @@ func NewTestGRPCServer(t *testing.T, cert tls.Certificate, secureConnection bool
 					return interceptor(ctx, in, info, h)
 				},
 			},
+			{
+				MethodName: "Ping",
+				Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+					in := new(wrapperspb.BytesValue)
+					if err := dec(in); err != nil {
+						return nil, err
+					}
+					return &wrapperspb.BytesValue{Value: []byte("pong")}, nil
+				},
+			},
 		},
 	}, struct{}{}) // implements empty interface
 
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// SameJSON compares documents ignoring formatting and key order, non JSON input is compared as is
+func SameJSON(a, b []byte) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}